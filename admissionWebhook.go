@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// admissionReview represents an admission.k8s.io/v1 admission review; only the fields needed to validate objects are decoded
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Kind      admissionKind   `json:"kind"`
+	Namespace string          `json:"namespace"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+type admissionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type admissionResponse struct {
+	UID      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Status   *admissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+type admissionStatus struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+// admissionService holds the service fields the webhook reads; the service type of the kubernetes client can't be decoded from json
+type admissionService struct {
+	Metadata *metav1.ObjectMeta `json:"metadata"`
+	Spec     struct {
+		LoadBalancerClass *string `json:"loadBalancerClass,omitempty"`
+	} `json:"spec"`
+}
+
+// startAdmissionWebhook serves the validating admission webhook over https; it runs on all replicas, since it doesn't depend on
+// being the leader
+func startAdmissionWebhook(client *k8s.Client, getDNSService func() *GoogleCloudDNSService) {
+	go func() {
+		portString := fmt.Sprintf(":%v", *webhookPort)
+		log.Info().Str("port", portString).Msg("Serving validating admission webhook...")
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+			handleAdmissionReview(w, r, client, getDNSService())
+		})
+
+		if err := http.ListenAndServeTLS(portString, *webhookCertFile, *webhookKeyFile, mux); err != nil {
+			log.Fatal().Err(err).Msg("Starting validating admission webhook listener failed")
+		}
+	}()
+}
+
+func handleAdmissionReview(w http.ResponseWriter, r *http.Request, client *k8s.Client, dnsService *GoogleCloudDNSService) {
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "expected an admission review with a request", http.StatusBadRequest)
+		return
+	}
+
+	response := validateAdmissionRequest(client, dnsService, review.Request)
+	response.UID = review.Request.UID
+
+	data, err := json.Marshal(admissionReview{
+		APIVersion: review.APIVersion,
+		Kind:       review.Kind,
+		Response:   response,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// validateAdmissionRequest checks the hostnames a service, ingress or dns endpoint is about to claim the same way they're checked when
+// setting dns records, so invalid hostnames, hostnames outside the zone, hostnames the domain policy rejects and hostnames that another
+// object takes precedence for are refused right away
+func validateAdmissionRequest(client *k8s.Client, dnsService *GoogleCloudDNSService, request *admissionRequest) *admissionResponse {
+
+	if request.Operation != "CREATE" && request.Operation != "UPDATE" {
+		return &admissionResponse{Allowed: true}
+	}
+
+	kind, metadata, hostnames, err := getAdmissionObjectHostnames(request.Kind.Kind, request.Namespace, request.Object)
+
+	// updates that don't change the hostnames, like the controller storing its state or removing its finalizer, are left alone, so
+	// objects that are already in conflict or have a broken hostname template can still be changed
+	if request.Operation == "UPDATE" && len(request.OldObject) > 0 {
+		_, _, oldHostnames, oldErr := getAdmissionObjectHostnames(request.Kind.Kind, request.Namespace, request.OldObject)
+		if (err == nil) == (oldErr == nil) && oldHostnames == hostnames {
+			return &admissionResponse{Allowed: true}
+		}
+	}
+
+	if err != nil {
+		return denyAdmission(err.Error())
+	}
+	if hostnames == "" {
+		return &admissionResponse{Allowed: true}
+	}
+
+	problems, err := validateClaimedHostnames(client, dnsService, kind, metadata, strings.Split(hostnames, ","))
+	if err != nil {
+		if *webhookFailOpen {
+			log.Warn().Err(err).Msgf("%v %v.%v - Validating hostnames failed, allowing it because the webhook fails open", kind, metadata.GetName(), metadata.GetNamespace())
+			return &admissionResponse{
+				Allowed:  true,
+				Warnings: []string{fmt.Sprintf("estafette-google-cloud-dns couldn't validate the dns hostnames: %v", err)},
+			}
+		}
+		return denyAdmission(fmt.Sprintf("validating the dns hostnames failed: %v", err))
+	}
+	if len(problems) > 0 {
+		return denyAdmission(strings.Join(problems, "; "))
+	}
+
+	return &admissionResponse{Allowed: true}
+}
+
+// getAdmissionObjectHostnames decodes a service, ingress or dns endpoint from an admission request and returns the hostnames it
+// claims; they're empty if the object doesn't have dns enabled or the controller doesn't process it, and an error is returned if its
+// hostname template fails to render or a record of a dns endpoint is invalid
+func getAdmissionObjectHostnames(objectKind, namespace string, object json.RawMessage) (kind string, metadata *metav1.ObjectMeta, hostnames string, err error) {
+
+	var hostnamesErr error
+
+	switch objectKind {
+	case "Service":
+		var service admissionService
+		if err := json.Unmarshal(object, &service); err != nil || service.Metadata == nil {
+			return "", nil, "", fmt.Errorf("decoding the service failed: %v", err)
+		}
+		if len(*loadBalancerClasses) > 0 && (service.Spec.LoadBalancerClass == nil || !foundation.StringArrayContains(*loadBalancerClasses, *service.Spec.LoadBalancerClass)) {
+			return "", nil, "", nil
+		}
+		kind = "Service"
+		metadata = service.Metadata
+		hostnames, hostnamesErr = getDesiredServiceHostnames(metadata)
+
+	case "Ingress":
+		var ingress Ingress
+		if err := json.Unmarshal(object, &ingress); err != nil || ingress.Metadata == nil {
+			return "", nil, "", fmt.Errorf("decoding the ingress failed: %v", err)
+		}
+		if !ingressClassMatches(&ingress) {
+			return "", nil, "", nil
+		}
+		kind = "Ingress"
+		metadata = ingress.Metadata
+		var state GoogleCloudDNSState
+		state, hostnamesErr = getDesiredIngressState(&ingress)
+		hostnames = state.Hostnames
+
+	case "DNSEndpoint":
+		if !*enableDNSEndpoints {
+			return "", nil, "", nil
+		}
+		var endpoint DNSEndpoint
+		if err := json.Unmarshal(object, &endpoint); err != nil || endpoint.Metadata == nil {
+			return "", nil, "", fmt.Errorf("decoding the dns endpoint failed: %v", err)
+		}
+		kind = "DNSEndpoint"
+		metadata = endpoint.Metadata
+		hostnames, hostnamesErr = getDNSEndpointHostnames(endpoint.Spec.Endpoints)
+
+	default:
+		return "", nil, "", nil
+	}
+
+	if metadata.Namespace == nil {
+		metadata.Namespace = k8s.String(namespace)
+	}
+	// dns endpoints don't need the annotation
+	if kind != "DNSEndpoint" && metadata.Annotations[annotationGoogleCloudDNS] != "true" || !isInScope(metadata) {
+		return "", nil, "", nil
+	}
+	if hostnamesErr != nil {
+		return "", nil, "", hostnamesErr
+	}
+
+	return kind, metadata, hostnames, nil
+}
+
+// validateClaimedHostnames returns a description of the problem with each hostname that can't be published for the object
+func validateClaimedHostnames(client *k8s.Client, dnsService *GoogleCloudDNSService, kind string, metadata *metav1.ObjectMeta, hostnames []string) (problems []string, err error) {
+
+	zoneDNSName, err := dnsService.GetZoneDNSName()
+	if err != nil {
+		return nil, err
+	}
+
+	claim := newHostnameClaim(strings.ToLower(kind), metadata)
+	if metadata.CreationTimestamp == nil {
+		// an object that is being created is newer than any existing object
+		claim.created = time.Now().Unix()
+	}
+	owner := ownerRecordValue(kind, metadata)
+
+	for _, hostname := range hostnames {
+		if !validateHostname(hostname) {
+			problems = append(problems, fmt.Sprintf("hostname %v is invalid", hostname))
+			continue
+		}
+		if !isHostnameInZone(hostname, zoneDNSName) {
+			problems = append(problems, fmt.Sprintf("hostname %v isn't within zone %v", hostname, zoneDNSName))
+			continue
+		}
+
+		allowed, err := isHostnameAllowed(client, metadata.GetNamespace(), hostname)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			problems = append(problems, fmt.Sprintf("the domain policy doesn't allow namespace %v to claim hostname %v", metadata.GetNamespace(), hostname))
+			continue
+		}
+
+		// the record is in conflict if it's owned by another controller instance, or by another object that still claims it and
+		// takes precedence
+		currentOwner, err := dnsService.GetDNSRecordOwner(hostname)
+		if err != nil {
+			return nil, err
+		}
+		if !isOwnershipConflict(currentOwner, owner) {
+			continue
+		}
+		if !isOwnedByThisController(currentOwner) {
+			problems = append(problems, fmt.Sprintf("the dns record of hostname %v is owned by %v", hostname, currentOwner))
+			continue
+		}
+
+		_, ownerKind, ownerNamespace, ownerName, _ := parseOwnerRecordValue(currentOwner)
+		ownerMetadata, known, err := getOwningObjectMetadata(client, ownerKind, ownerNamespace, ownerName)
+		if err != nil {
+			return nil, err
+		}
+		if !known || ownerMetadata == nil {
+			continue
+		}
+		claimed, err := claimsHostname(client, ownerKind, ownerMetadata, hostname)
+		if err != nil {
+			return nil, err
+		}
+		if claimed && newHostnameClaim(ownerKind, ownerMetadata).wins(claim) {
+			problems = append(problems, fmt.Sprintf("hostname %v is claimed by %v %v.%v, which takes precedence", hostname, ownerKind, ownerName, ownerNamespace))
+		}
+	}
+
+	return problems, nil
+}
+
+func denyAdmission(message string) *admissionResponse {
+	return &admissionResponse{
+		Allowed: false,
+		Status: &admissionStatus{
+			Code:    http.StatusForbidden,
+			Message: fmt.Sprintf("estafette-google-cloud-dns: %v", message),
+		},
+	}
+}
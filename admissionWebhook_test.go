@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetAdmissionObjectHostnamesForDNSEndpoints(t *testing.T) {
+
+	defaultEnableDNSEndpoints := *enableDNSEndpoints
+	defer func() { *enableDNSEndpoints = defaultEnableDNSEndpoints }()
+
+	tests := []struct {
+		name      string
+		enabled   bool
+		object    string
+		hostnames string
+		wantErr   bool
+	}{
+		{
+			name:      "valid records",
+			enabled:   true,
+			object:    `{"metadata":{"name":"vm","namespace":"default"},"spec":{"endpoints":[{"name":"vm.example.com","type":"A","targets":["10.0.0.1"]},{"name":"vm.example.com","type":"AAAA","targets":["2001:db8::1"]},{"name":"www.example.com","type":"CNAME","targets":["vm.example.com"]}]}}`,
+			hostnames: "vm.example.com,www.example.com",
+		},
+		{
+			name:    "owner record name",
+			enabled: true,
+			object:  `{"metadata":{"name":"vm","namespace":"default"},"spec":{"endpoints":[{"name":"_estafette-google-cloud-dns.www.example.com","type":"TXT","targets":["heritage=estafette-google-cloud-dns,owner=default,resource=service/default/vm"]}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid target",
+			enabled: true,
+			object:  `{"metadata":{"name":"vm","namespace":"default"},"spec":{"endpoints":[{"name":"vm.example.com","type":"A","targets":["vm.internal"]}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "dns endpoints disabled",
+			enabled: false,
+			object:  `{"metadata":{"name":"vm","namespace":"default"},"spec":{"endpoints":[{"name":"_estafette-google-cloud-dns.www.example.com","type":"TXT","targets":["x"]}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*enableDNSEndpoints = tt.enabled
+
+			_, _, hostnames, err := getAdmissionObjectHostnames("DNSEndpoint", "default", []byte(tt.object))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getAdmissionObjectHostnames() error = %v, want error %v", err, tt.wantErr)
+			}
+			if hostnames != tt.hostnames {
+				t.Errorf("getAdmissionObjectHostnames() = %q, want %q", hostnames, tt.hostnames)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// dnsEndpointRecordTypes are the record types a dns endpoint can set
+var dnsEndpointRecordTypes = []string{"A", "AAAA", "CNAME", "TXT", "MX", "SRV", "CAA", "NS", "PTR"}
+
+const (
+	// dnsEndpointConditionReady is the condition telling whether all records of a dns endpoint have been set
+	dnsEndpointConditionReady string = "Ready"
+
+	dnsEndpointReasonSynced  string = "RecordsSynced"
+	dnsEndpointReasonSkipped string = "RecordsSkipped"
+	dnsEndpointReasonFailed  string = "SyncFailed"
+)
+
+// DNSEndpoint represents a dns.estafette.io/v1 dns endpoint, listing records for things that aren't a service or ingress, like the
+// ip address of a vm or a cname to a saas provider
+type DNSEndpoint struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Metadata   *metav1.ObjectMeta `json:"metadata"`
+	Spec       DNSEndpointSpec    `json:"spec"`
+	Status     DNSEndpointStatus  `json:"status"`
+}
+
+// GetMetadata returns the metadata of the dns endpoint, required to implement k8s.Resource
+func (e *DNSEndpoint) GetMetadata() *metav1.ObjectMeta {
+	return e.Metadata
+}
+
+// DNSEndpointList represents a list of dns endpoints
+type DNSEndpointList struct {
+	Metadata *metav1.ListMeta `json:"metadata"`
+	Items    []*DNSEndpoint   `json:"items"`
+}
+
+// GetMetadata returns the metadata of the dns endpoint list, required to implement k8s.ResourceList
+func (l *DNSEndpointList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+// DNSEndpointSpec lists the records to set
+type DNSEndpointSpec struct {
+	Endpoints []DNSEndpointRecord `json:"endpoints,omitempty"`
+}
+
+// DNSEndpointRecord is a record to set, like www.example.com CNAME example.saas-provider.com.
+type DNSEndpointRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// TTL is the time to live in seconds, defaults to the time to live of the other records set by the controller
+	TTL     int64    `json:"ttl,omitempty"`
+	Targets []string `json:"targets"`
+}
+
+// DNSEndpointStatus represents the result of the last sync of a dns endpoint
+type DNSEndpointStatus struct {
+	ObservedGeneration int64                       `json:"observedGeneration,omitempty"`
+	Conditions         []DNSEndpointCondition      `json:"conditions,omitempty"`
+	Records            []GoogleCloudDNSRecordState `json:"records,omitempty"`
+	LastSyncTime       string                      `json:"lastSyncTime,omitempty"`
+	LastError          string                      `json:"lastError,omitempty"`
+	ChangeID           string                      `json:"changeId,omitempty"`
+}
+
+// DNSEndpointCondition represents a condition of a dns endpoint
+type DNSEndpointCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+}
+
+func init() {
+	k8s.Register(estafetteDNSAPIGroup, "v1", "dnsendpoints", true, &DNSEndpoint{})
+	k8s.RegisterList(estafetteDNSAPIGroup, "v1", "dnsendpoints", true, &DNSEndpointList{})
+}
+
+// getDNSEndpointState returns the state of a dns endpoint from its status
+func getDNSEndpointState(endpoint *DNSEndpoint) (state GoogleCloudDNSState) {
+	hostnames := []string{}
+	for _, record := range endpoint.Status.Records {
+		hostnames = append(hostnames, record.Hostname)
+	}
+
+	return GoogleCloudDNSState{
+		SchemaVersion: stateSchemaVersion,
+		Enabled:       "true",
+		Hostnames:     joinHostnames(hostnames),
+		Records:       endpoint.Status.Records,
+		LastSyncTime:  endpoint.Status.LastSyncTime,
+		LastError:     endpoint.Status.LastError,
+		ChangeID:      endpoint.Status.ChangeID,
+	}
+}
+
+// normalizeDNSEndpointRecord validates a record of a dns endpoint and returns it in the form it's set in, with the default time
+// to live and fully qualified or quoted targets where the record type needs them
+func normalizeDNSEndpointRecord(record DNSEndpointRecord) (DNSEndpointRecord, error) {
+
+	record.Name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(record.Name), "."))
+	record.Type = strings.ToUpper(strings.TrimSpace(record.Type))
+	if record.TTL == 0 {
+		record.TTL = dnsRecordTTL
+	}
+
+	if isOwnerRecordName(record.Name) {
+		return record, fmt.Errorf("name %v is reserved for the owner records of the controller", record.Name)
+	}
+	if !foundation.StringArrayContains(dnsEndpointRecordTypes, record.Type) {
+		return record, fmt.Errorf("record type %v isn't supported", record.Type)
+	}
+	if record.TTL < 0 {
+		return record, fmt.Errorf("ttl %v is negative", record.TTL)
+	}
+	if len(record.Targets) == 0 {
+		return record, fmt.Errorf("it has no targets")
+	}
+	if record.Type == "CNAME" && len(record.Targets) > 1 {
+		return record, fmt.Errorf("a cname can only have a single target")
+	}
+
+	targets := []string{}
+	for _, target := range record.Targets {
+		target = strings.TrimSpace(target)
+		switch record.Type {
+		case "A":
+			if ip := net.ParseIP(target); ip == nil || ip.To4() == nil {
+				return record, fmt.Errorf("target %v isn't an ipv4 address", target)
+			}
+		case "AAAA":
+			if ip := net.ParseIP(target); ip == nil || ip.To4() != nil {
+				return record, fmt.Errorf("target %v isn't an ipv6 address", target)
+			}
+		case "CNAME", "NS", "PTR":
+			if !strings.HasSuffix(target, ".") {
+				target += "."
+			}
+		case "TXT":
+			if !strings.HasPrefix(target, "\"") {
+				target = fmt.Sprintf("%q", target)
+			}
+		}
+		targets = append(targets, target)
+	}
+	record.Targets = targets
+
+	return record, nil
+}
+
+// getDNSEndpointHostnames returns the names of the records of a dns endpoint comma separated, or an error describing the first record
+// that can't be set
+func getDNSEndpointHostnames(records []DNSEndpointRecord) (hostnames string, err error) {
+	names := []string{}
+	for _, record := range records {
+		record, err := normalizeDNSEndpointRecord(record)
+		if err != nil {
+			return "", fmt.Errorf("dns record %v (%v) is invalid: %v", record.Name, record.Type, err)
+		}
+		if !foundation.StringArrayContains(names, record.Name) {
+			names = append(names, record.Name)
+		}
+	}
+	return strings.Join(names, ","), nil
+}
+
+// newDNSEndpointRecordState returns the state of a record of a dns endpoint
+func newDNSEndpointRecordState(record DNSEndpointRecord, status string) GoogleCloudDNSRecordState {
+	return GoogleCloudDNSRecordState{
+		Hostname: record.Name,
+		Type:     record.Type,
+		TTL:      record.TTL,
+		Zone:     *googleCloudDNSZone,
+		Status:   status,
+		Targets:  record.Targets,
+	}
+}
+
+func dnsEndpointRecordKey(hostname, recordType string) string {
+	return fmt.Sprintf("%v/%v", hostname, recordType)
+}
+
+func processDNSEndpoint(dnsService *GoogleCloudDNSService, client *k8s.Client, endpoint *DNSEndpoint, initiator string) (status string, err error) {
+
+	status = "failed"
+
+	if endpoint == nil || endpoint.Metadata == nil || !isBeingReleased(endpoint.Metadata) && !isInScope(endpoint.Metadata) {
+		return "skipped", nil
+	}
+
+	currentState := getDNSEndpointState(endpoint)
+
+	// delete the dns records of a dns endpoint that is being deleted before letting it go
+	if endpoint.Metadata.DeletionTimestamp != nil {
+		hostnameClaims.Release(newHostnameClaim(dnsEndpointKind.kind, endpoint.Metadata).item)
+		return releaseResource(dnsService, client, "DNSEndpoint", endpoint, initiator, currentState)
+	}
+
+	return makeDNSEndpointChanges(dnsService, client, endpoint, initiator, currentState)
+}
+
+// makeDNSEndpointChanges sets the records listed by a dns endpoint and deletes the ones that have been removed from it, and writes the
+// outcome to its status
+func makeDNSEndpointChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, endpoint *DNSEndpoint, initiator string, currentState GoogleCloudDNSState) (status string, err error) {
+	statusUpToDate := endpoint.Status.ObservedGeneration == endpoint.Metadata.GetGeneration()
+	return makeRecordSetChanges(dnsService, client, "DNSEndpoint", endpoint, initiator, endpoint.Spec.Endpoints, currentState, statusUpToDate)
+}
+
+// updateDNSEndpointStatus patches the finalizer of a dns endpoint and writes the state and the ready condition to its status
+func updateDNSEndpointStatus(client *k8s.Client, endpoint *DNSEndpoint, initiator string, state GoogleCloudDNSState) error {
+
+	kind := "DNSEndpoint"
+	metadata := endpoint.Metadata
+
+	err := patchMetadata(client, kind, endpoint, nil)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] %v %v.%v - Updating %v finalizers has failed", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+		return err
+	}
+
+	endpoint.Status.ObservedGeneration = metadata.GetGeneration()
+	endpoint.Status.Records = state.Records
+	endpoint.Status.LastSyncTime = state.LastSyncTime
+	endpoint.Status.LastError = state.LastError
+	endpoint.Status.ChangeID = state.ChangeID
+	setDNSEndpointCondition(&endpoint.Status, getDNSEndpointReadyCondition(state, metadata.GetGeneration()))
+
+	err = client.Update(context.Background(), endpoint, k8s.Subresource("status"))
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] %v %v.%v - Updating %v status has failed", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+		return err
+	}
+
+	return nil
+}
+
+// getDNSEndpointReadyCondition returns the ready condition for the state of a dns endpoint; it's only true if all records are set
+func getDNSEndpointReadyCondition(state GoogleCloudDNSState, generation int64) DNSEndpointCondition {
+
+	condition := DNSEndpointCondition{
+		Type:               dnsEndpointConditionReady,
+		Status:             "True",
+		ObservedGeneration: generation,
+		Reason:             dnsEndpointReasonSynced,
+		Message:            fmt.Sprintf("All %v dns records have been set", len(state.Records)),
+	}
+
+	skipped := []string{}
+	for _, record := range state.Records {
+		if !isSyncedRecordStatus(record.Status) && record.Status != recordStatusFailed {
+			skipped = append(skipped, fmt.Sprintf("%v (%v) is %v", record.Hostname, record.Type, record.Status))
+		}
+	}
+
+	if state.LastError != "" {
+		condition.Status = "False"
+		condition.Reason = dnsEndpointReasonFailed
+		condition.Message = state.LastError
+	} else if len(skipped) > 0 {
+		condition.Status = "False"
+		condition.Reason = dnsEndpointReasonSkipped
+		condition.Message = fmt.Sprintf("%v of %v dns records have been skipped: %v", len(skipped), len(state.Records), strings.Join(skipped, ", "))
+	}
+
+	return condition
+}
+
+// setDNSEndpointCondition adds or replaces a condition, keeping its transition time if its status didn't change
+func setDNSEndpointCondition(status *DNSEndpointStatus, condition DNSEndpointCondition) {
+	condition.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+
+	for i, existing := range status.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return
+	}
+
+	status.Conditions = append(status.Conditions, condition)
+}
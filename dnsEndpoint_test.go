@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeDNSEndpointRecord(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		record  DNSEndpointRecord
+		want    DNSEndpointRecord
+		wantErr bool
+	}{
+		{
+			name:   "a record with the default ttl",
+			record: DNSEndpointRecord{Name: " VM.Example.com. ", Type: "a", Targets: []string{" 10.0.0.1 "}},
+			want:   DNSEndpointRecord{Name: "vm.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "aaaa record with its own ttl",
+			record: DNSEndpointRecord{Name: "vm.example.com", Type: "AAAA", TTL: 60, Targets: []string{"2001:db8::1"}},
+			want:   DNSEndpointRecord{Name: "vm.example.com", Type: "AAAA", TTL: 60, Targets: []string{"2001:db8::1"}},
+		},
+		{
+			name:   "cname target gets fully qualified",
+			record: DNSEndpointRecord{Name: "www.example.com", Type: "CNAME", Targets: []string{"example.saas-provider.com"}},
+			want:   DNSEndpointRecord{Name: "www.example.com", Type: "CNAME", TTL: dnsRecordTTL, Targets: []string{"example.saas-provider.com."}},
+		},
+		{
+			name:   "txt target gets quoted",
+			record: DNSEndpointRecord{Name: "example.com", Type: "TXT", Targets: []string{"v=spf1 -all", "\"quoted\""}},
+			want:   DNSEndpointRecord{Name: "example.com", Type: "TXT", TTL: dnsRecordTTL, Targets: []string{"\"v=spf1 -all\"", "\"quoted\""}},
+		},
+		{
+			name:   "mx target is left alone",
+			record: DNSEndpointRecord{Name: "example.com", Type: "MX", Targets: []string{"10 mail.example.com."}},
+			want:   DNSEndpointRecord{Name: "example.com", Type: "MX", TTL: dnsRecordTTL, Targets: []string{"10 mail.example.com."}},
+		},
+		{
+			name:    "unsupported type",
+			record:  DNSEndpointRecord{Name: "example.com", Type: "SOA", Targets: []string{"x"}},
+			wantErr: true,
+		},
+		{
+			name:    "negative ttl",
+			record:  DNSEndpointRecord{Name: "vm.example.com", Type: "A", TTL: -1, Targets: []string{"10.0.0.1"}},
+			wantErr: true,
+		},
+		{
+			name:    "no targets",
+			record:  DNSEndpointRecord{Name: "vm.example.com", Type: "A"},
+			wantErr: true,
+		},
+		{
+			name:    "cname with multiple targets",
+			record:  DNSEndpointRecord{Name: "www.example.com", Type: "CNAME", Targets: []string{"a.example.org", "b.example.org"}},
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 address in an a record",
+			record:  DNSEndpointRecord{Name: "vm.example.com", Type: "A", Targets: []string{"2001:db8::1"}},
+			wantErr: true,
+		},
+		{
+			name:    "ipv4 address in an aaaa record",
+			record:  DNSEndpointRecord{Name: "vm.example.com", Type: "AAAA", Targets: []string{"10.0.0.1"}},
+			wantErr: true,
+		},
+		{
+			name:    "owner record name",
+			record:  DNSEndpointRecord{Name: "_estafette-google-cloud-dns.www.example.com", Type: "TXT", Targets: []string{"heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a"}},
+			wantErr: true,
+		},
+		{
+			name:    "owner record name in another case",
+			record:  DNSEndpointRecord{Name: "_Estafette-Google-Cloud-DNS.www.example.com.", Type: "A", Targets: []string{"10.0.0.1"}},
+			wantErr: true,
+		},
+		{
+			name:    "hostname in an a record",
+			record:  DNSEndpointRecord{Name: "vm.example.com", Type: "A", Targets: []string{"vm.internal"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := normalizeDNSEndpointRecord(tt.record)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: normalizeDNSEndpointRecord() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: normalizeDNSEndpointRecord() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
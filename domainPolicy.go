@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// domainPolicyConfigMapKey is the key in the policy config map that holds the policy
+const domainPolicyConfigMapKey string = "policy.json"
+
+// namespaceLabelsTTL is how long the labels of a namespace are cached for evaluating namespace selectors
+const namespaceLabelsTTL = 1 * time.Minute
+
+// DomainPolicy authorizes namespaces to claim hostnames; when a policy is set a hostname is only published if any of its rules
+// allows it for the namespace of the object
+type DomainPolicy struct {
+	Rules []DomainPolicyRule `json:"rules"`
+}
+
+// DomainPolicyRule allows the namespaces listed or matching the selector to claim hostnames within the domains or matching the
+// patterns
+type DomainPolicyRule struct {
+	// Namespaces lists the namespaces the rule applies to; * applies it to all namespaces
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector is a label selector for the namespaces the rule applies to
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// Domains lists domain suffixes; a hostname is allowed if it equals one of them or is a subdomain of it
+	Domains []string `json:"domains,omitempty"`
+	// Patterns lists hostname patterns in which * matches (part of) a single label, for example api-*.example.com
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+var (
+	domainPolicy      *DomainPolicy
+	domainPolicyMutex = &sync.RWMutex{}
+
+	namespaceLabels      = map[string]map[string]string{}
+	namespaceLabelsTimes = map[string]time.Time{}
+	namespaceLabelsMutex = &sync.Mutex{}
+)
+
+// parseDomainPolicy decodes and validates a policy
+func parseDomainPolicy(data []byte) (*DomainPolicy, error) {
+	var policy DomainPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		if len(rule.Namespaces) == 0 && rule.NamespaceSelector == "" {
+			return nil, fmt.Errorf("rule %v has neither namespaces nor a namespace selector", i)
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %v has invalid pattern %v: %v", i, pattern, err)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+func setDomainPolicy(policy *DomainPolicy) {
+	domainPolicyMutex.Lock()
+	defer domainPolicyMutex.Unlock()
+
+	domainPolicy = policy
+}
+
+func getDomainPolicy() *DomainPolicy {
+	domainPolicyMutex.RLock()
+	defer domainPolicyMutex.RUnlock()
+
+	return domainPolicy
+}
+
+// loadDomainPolicyFile reads the policy from a file and watches the file to reload it when it changes
+func loadDomainPolicyFile(filename string) error {
+
+	load := func() error {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		policy, err := parseDomainPolicy(data)
+		if err != nil {
+			return err
+		}
+		setDomainPolicy(policy)
+		log.Info().Msgf("Loaded domain policy with %v rules from %v", len(policy.Rules), filename)
+		return nil
+	}
+
+	if err := load(); err != nil {
+		return err
+	}
+
+	foundation.WatchForFileChanges(filename, func(event fsnotify.Event) {
+		log.Info().Msg("Domain policy file changed, reloading...")
+		if err := load(); err != nil {
+			log.Error().Err(err).Msg("Reloading domain policy failed, keeping the current policy")
+		}
+	})
+
+	return nil
+}
+
+// loadDomainPolicyConfigMap reads the policy from a config map in the form namespace/name and keeps polling it for changes
+func loadDomainPolicyConfigMap(client *k8s.Client, configMap string) error {
+
+	parts := strings.SplitN(configMap, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("config map %v is not in the form namespace/name", configMap)
+	}
+
+	lastData := ""
+	load := func() error {
+		var cm corev1.ConfigMap
+		err := client.Get(context.Background(), parts[0], parts[1], &cm)
+		if err != nil {
+			return err
+		}
+		data, ok := cm.Data[domainPolicyConfigMapKey]
+		if !ok {
+			return fmt.Errorf("config map %v has no %v key", configMap, domainPolicyConfigMapKey)
+		}
+		if data == lastData {
+			return nil
+		}
+		policy, err := parseDomainPolicy([]byte(data))
+		if err != nil {
+			return err
+		}
+		setDomainPolicy(policy)
+		lastData = data
+		log.Info().Msgf("Loaded domain policy with %v rules from config map %v", len(policy.Rules), configMap)
+		return nil
+	}
+
+	if err := load(); err != nil {
+		return err
+	}
+
+	go func() {
+		// loop indefinitely
+		for {
+			time.Sleep(time.Duration(foundation.ApplyJitter(60)) * time.Second)
+			if err := load(); err != nil {
+				log.Error().Err(err).Msg("Reloading domain policy failed, keeping the current policy")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// isHostnameAllowed returns true if no policy is set or the policy allows the namespace to claim the hostname
+func isHostnameAllowed(client *k8s.Client, namespace, hostname string) (bool, error) {
+
+	policy := getDomainPolicy()
+	if policy == nil {
+		return true, nil
+	}
+
+	for _, rule := range policy.Rules {
+		if !rule.allowsHostname(hostname) {
+			continue
+		}
+		applies, err := rule.appliesToNamespace(client, namespace)
+		if err != nil {
+			return false, err
+		}
+		if applies {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// allowsHostname returns true if the hostname is within one of the domains or matches one of the patterns of the rule
+func (rule DomainPolicyRule) allowsHostname(hostname string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	for _, domain := range rule.Domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+
+	hostnameLabels := strings.Split(hostname, ".")
+	for _, pattern := range rule.Patterns {
+		patternLabels := strings.Split(strings.ToLower(strings.TrimSuffix(pattern, ".")), ".")
+		if len(patternLabels) != len(hostnameLabels) {
+			continue
+		}
+		matches := true
+		for i := range patternLabels {
+			if ok, _ := path.Match(patternLabels[i], hostnameLabels[i]); !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appliesToNamespace returns true if the namespace is listed in the rule or its labels match the namespace selector of the rule
+func (rule DomainPolicyRule) appliesToNamespace(client *k8s.Client, namespace string) (bool, error) {
+	if foundation.StringArrayContains(rule.Namespaces, namespace) || foundation.StringArrayContains(rule.Namespaces, "*") {
+		return true, nil
+	}
+	// cluster-scoped objects like nodes don't have a namespace, only rules for all namespaces apply to them
+	if rule.NamespaceSelector == "" || namespace == "" {
+		return false, nil
+	}
+
+	labels, err := getNamespaceLabels(client, namespace)
+	if err != nil {
+		return false, err
+	}
+
+	return labelSelectorMatches(rule.NamespaceSelector, labels), nil
+}
+
+// getNamespaceLabels returns the labels of a namespace, cached for a short while
+func getNamespaceLabels(client *k8s.Client, namespace string) (map[string]string, error) {
+	namespaceLabelsMutex.Lock()
+	defer namespaceLabelsMutex.Unlock()
+
+	if fetched, ok := namespaceLabelsTimes[namespace]; ok && time.Since(fetched) < namespaceLabelsTTL {
+		return namespaceLabels[namespace], nil
+	}
+
+	var ns corev1.Namespace
+	err := client.Get(context.Background(), "", namespace, &ns)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceLabels[namespace] = ns.GetMetadata().GetLabels()
+	namespaceLabelsTimes[namespace] = time.Now()
+
+	return namespaceLabels[namespace], nil
+}
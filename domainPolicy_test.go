@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAllowsHostname(t *testing.T) {
+
+	rule := DomainPolicyRule{
+		Namespaces: []string{"shop"},
+		Domains:    []string{"shop.example.com", ".Internal.Example.com."},
+		Patterns:   []string{"api-*.example.com"},
+	}
+
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"shop.example.com", true},
+		{"www.shop.example.com", true},
+		{"a.b.shop.example.com", true},
+		{"WWW.Shop.Example.com.", true},
+		{"myshop.example.com", false},
+		{"shop.example.com.evil.com", false},
+		{"internal.example.com", true},
+		{"db.internal.example.com", true},
+		{"api-v1.example.com", true},
+		{"api-.example.com", true},
+		{"api-v1.eu.example.com", false},
+		{"www.api-v1.example.com", false},
+		{"api.example.com", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := rule.allowsHostname(tt.hostname); got != tt.want {
+			t.Errorf("allowsHostname(%q) = %v, want %v", tt.hostname, got, tt.want)
+		}
+	}
+}
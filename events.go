@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// eventComponent is the component events are recorded as
+const eventComponent string = "estafette-google-cloud-dns"
+
+const (
+	eventTypeNormal  string = "Normal"
+	eventTypeWarning string = "Warning"
+)
+
+// reasons of the events recorded on processed objects, shown by kubectl describe
+const (
+	eventReasonRecordCreated        string = "DNSRecordCreated"
+	eventReasonRecordUpdated        string = "DNSRecordUpdated"
+	eventReasonRecordDeleted        string = "DNSRecordDeleted"
+	eventReasonForceReleased        string = "ForceReleased"
+	eventReasonInvalidHostname      string = "InvalidHostname"
+	eventReasonInvalidEndpoint      string = "InvalidEndpoint"
+	eventReasonInvalidAddressSource string = "InvalidAddressSource"
+	eventReasonAPIError             string = "APIError"
+	eventReasonOwnershipConflict    string = "OwnershipConflict"
+	eventReasonHostnameConflict     string = "HostnameConflict"
+	eventReasonPolicyViolation      string = "PolicyViolation"
+)
+
+// recordEvent records a kubernetes event on the object, so the outcome of processing it can be seen without access to the
+// controller logs; failing to record an event is logged but doesn't fail processing
+func recordEvent(client *k8s.Client, kind string, resource k8s.Resource, eventType, reason, messageFormat string, args ...interface{}) {
+
+	metadata := resource.GetMetadata()
+	message := fmt.Sprintf(messageFormat, args...)
+	now := time.Now()
+	seconds := now.Unix()
+	timestamp := &metav1.Time{Seconds: &seconds, Nanos: k8s.Int32(int32(now.Nanosecond()))}
+
+	// like kubernetes itself, events on cluster-scoped objects like nodes are recorded in the default namespace
+	eventNamespace := metadata.GetNamespace()
+	if eventNamespace == "" {
+		eventNamespace = "default"
+	}
+
+	event := &corev1.Event{
+		Metadata: &metav1.ObjectMeta{
+			// the name has to be unique, like the events recorded by kubernetes itself it's suffixed with the time in nanoseconds
+			Name:      k8s.String(fmt.Sprintf("%v.%x", metadata.GetName(), now.UnixNano())),
+			Namespace: k8s.String(eventNamespace),
+		},
+		InvolvedObject: &corev1.ObjectReference{
+			Kind:            k8s.String(kind),
+			Namespace:       metadata.Namespace,
+			Name:            metadata.Name,
+			Uid:             metadata.Uid,
+			ApiVersion:      k8s.String(involvedObjectAPIVersion(kind)),
+			ResourceVersion: metadata.ResourceVersion,
+		},
+		Reason:             k8s.String(reason),
+		Message:            k8s.String(message),
+		Source:             &corev1.EventSource{Component: k8s.String(eventComponent)},
+		FirstTimestamp:     timestamp,
+		LastTimestamp:      timestamp,
+		Count:              k8s.Int32(1),
+		Type:               k8s.String(eventType),
+		ReportingComponent: k8s.String(eventComponent),
+	}
+
+	err := client.Create(context.Background(), event)
+	if err != nil {
+		log.Warn().Err(err).Msgf("%v %v.%v - Recording %v event failed", kind, metadata.GetName(), metadata.GetNamespace(), reason)
+	}
+}
+
+// involvedObjectAPIVersion returns the api version of the kinds of objects events are recorded on
+func involvedObjectAPIVersion(kind string) string {
+	switch strings.ToLower(kind) {
+	case ingressKind.kind:
+		return ingressAPIVersion
+	case gatewayKind.kind:
+		return fmt.Sprintf("%v/v1", gatewayAPIGroup)
+	case dnsEndpointKind.kind:
+		return fmt.Sprintf("%v/v1", estafetteDNSAPIGroup)
+	default:
+		return "v1"
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// finalizerGoogleCloudDNS is added to the objects the controller sets dns records for, so their records are deleted before the
+// objects are removed, even when they're deleted while the controller isn't running
+const finalizerGoogleCloudDNS string = "estafette.io/google-cloud-dns"
+
+// annotationGoogleCloudDNSForceRelease set to true makes the controller remove its finalizer from a deleted object even if its dns
+// records can't be deleted
+const annotationGoogleCloudDNSForceRelease string = "estafette.io/google-cloud-dns-force-release"
+
+func hasFinalizer(metadata *metav1.ObjectMeta) bool {
+	for _, finalizer := range metadata.Finalizers {
+		if finalizer == finalizerGoogleCloudDNS {
+			return true
+		}
+	}
+	return false
+}
+
+func addFinalizer(metadata *metav1.ObjectMeta) {
+	if !hasFinalizer(metadata) {
+		metadata.Finalizers = append(metadata.Finalizers, finalizerGoogleCloudDNS)
+	}
+}
+
+func removeFinalizer(metadata *metav1.ObjectMeta) {
+	finalizers := []string{}
+	for _, finalizer := range metadata.Finalizers {
+		if finalizer != finalizerGoogleCloudDNS {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	metadata.Finalizers = finalizers
+}
+
+// isBeingReleased returns true if the object is being deleted while it still carries the finalizer; its records are deleted whatever
+// the scope and class filters say, since nothing else removes the finalizer of an object that has left scope
+func isBeingReleased(metadata *metav1.ObjectMeta) bool {
+	return metadata != nil && metadata.DeletionTimestamp != nil && hasFinalizer(metadata)
+}
+
+// hasLeftLabelSelector returns true if the watch reports an object as deleted while it still carries the finalizer and isn't being
+// deleted; an object with a finalizer is only removed after the finalizer is, so it no longer matches the label selector instead
+func hasLeftLabelSelector(event string, metadata *metav1.ObjectMeta) bool {
+	return event == k8s.EventDeleted && metadata != nil && metadata.DeletionTimestamp == nil && hasFinalizer(metadata)
+}
+
+// letGo removes the finalizer from an object that has left the label selector, because its deletion is no longer watched; its
+// records are kept until the garbage collection finds the object gone
+func letGo(client *k8s.Client, kind string, resource k8s.Resource, initiator string) error {
+
+	metadata := resource.GetMetadata()
+
+	log.Info().Msgf("[%v] %v %v.%v - Removing finalizer because %v no longer matches the label selector...", initiator, kind, metadata.GetName(), metadata.GetNamespace(), strings.ToLower(kind))
+
+	removeFinalizer(metadata)
+
+	return updateFinalizers(client, kind, resource, initiator)
+}
+
+// updateFinalizers patches the finalizers of the resource after they have been changed
+func updateFinalizers(client *k8s.Client, kind string, resource k8s.Resource, initiator string) error {
+
+	metadata := resource.GetMetadata()
+
+	err := patchMetadata(client, kind, resource, nil)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] %v %v.%v - Updating %v finalizers has failed", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+		return err
+	}
+
+	return nil
+}
+
+// getSyncedHostnames returns the hostnames that have a dns record according to the state
+func getSyncedHostnames(state GoogleCloudDNSState) (hostnames []string) {
+	for _, record := range state.Records {
+		if isSyncedRecordStatus(record.Status) {
+			hostnames = append(hostnames, record.Hostname)
+		}
+	}
+	return
+}
+
+// releaseResource deletes the dns records of an object that is being deleted and then removes the finalizer, so kubernetes can
+// remove the object; if deleting fails the finalizer is kept and the error is reported, unless the force release annotation is set
+func releaseResource(dnsService *GoogleCloudDNSService, client *k8s.Client, kind string, resource k8s.Resource, initiator string, currentState GoogleCloudDNSState) (status string, err error) {
+
+	status = "failed"
+
+	metadata := resource.GetMetadata()
+
+	if !hasFinalizer(metadata) {
+		return "skipped", nil
+	}
+
+	log.Info().Msgf("[%v] %v %v.%v - Deleting dns records because %v is being deleted...", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+
+	owner := ownerRecordValue(kind, metadata)
+	forceRelease := metadata.Annotations[annotationGoogleCloudDNSForceRelease] == "true"
+
+	for _, record := range currentState.Records {
+		if !isSyncedRecordStatus(record.Status) {
+			continue
+		}
+
+		deleted, _, err := dnsService.DeleteDNSRecord(record.Type, record.Hostname, owner)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) failed", initiator, kind, *metadata.Name, *metadata.Namespace, record.Hostname, record.Type)
+			recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Deleting dns record %v (%v) failed, %v is kept until it succeeds or the %v annotation is set to true: %v", record.Hostname, record.Type, strings.ToLower(kind), annotationGoogleCloudDNSForceRelease, err)
+
+			if forceRelease {
+				continue
+			}
+
+			failedState := currentState
+			failedState.LastError = err.Error()
+			if failedState.LastError != currentState.LastError {
+				if stateErr := updateState(client, kind, resource, initiator, failedState); stateErr != nil {
+					log.Warn().Err(stateErr).Msgf("[%v] %v %v.%v - Storing the error in the state failed", initiator, kind, *metadata.Name, *metadata.Namespace)
+				}
+			}
+
+			return status, err
+		}
+
+		if deleted {
+			recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordDeleted, "Deleted dns record %v (%v)", record.Hostname, record.Type)
+		}
+	}
+
+	if forceRelease {
+		log.Warn().Msgf("[%v] %v %v.%v - Releasing %v because the %v annotation is set", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind), annotationGoogleCloudDNSForceRelease)
+		recordEvent(client, kind, resource, eventTypeWarning, eventReasonForceReleased, "Released %v without making sure its dns records have been deleted, because the %v annotation is set", strings.ToLower(kind), annotationGoogleCloudDNSForceRelease)
+	}
+
+	removeFinalizer(metadata)
+	err = updateFinalizers(client, kind, resource, initiator)
+	if err != nil {
+		return status, err
+	}
+
+	log.Info().Msgf("[%v] %v %v.%v - Removed finalizer after deleting dns records", initiator, kind, *metadata.Name, *metadata.Namespace)
+
+	return "succeeded", nil
+}
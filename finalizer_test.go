@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestIsBeingReleased(t *testing.T) {
+
+	defaultLabelSelector := *labelSelector
+	defer func() { *labelSelector = defaultLabelSelector }()
+	*labelSelector = "app=shop"
+
+	deletionTimestamp := &metav1.Time{Seconds: proto.Int64(1)}
+
+	tests := []struct {
+		name     string
+		metadata *metav1.ObjectMeta
+		want     bool
+	}{
+		{"NotDeleted", &metav1.ObjectMeta{Finalizers: []string{finalizerGoogleCloudDNS}}, false},
+		{"DeletedWithoutFinalizer", &metav1.ObjectMeta{DeletionTimestamp: deletionTimestamp, Finalizers: []string{"other"}}, false},
+		{"DeletedWithFinalizer", &metav1.ObjectMeta{DeletionTimestamp: deletionTimestamp, Labels: map[string]string{"app": "shop"}, Finalizers: []string{finalizerGoogleCloudDNS}}, true},
+		{"DeletedWithFinalizerOutOfScope", &metav1.ObjectMeta{DeletionTimestamp: deletionTimestamp, Labels: map[string]string{"app": "cart"}, Finalizers: []string{finalizerGoogleCloudDNS}}, true},
+		{"Nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBeingReleased(tt.metadata); got != tt.want {
+				t.Errorf("isBeingReleased() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// the out of scope object is released although the scope check alone would skip it
+	if isInScope(tests[3].metadata) {
+		t.Errorf("isInScope() = true for labels %v, want false", tests[3].metadata.Labels)
+	}
+}
+
+func TestHasLeftLabelSelector(t *testing.T) {
+
+	deletionTimestamp := &metav1.Time{Seconds: proto.Int64(1)}
+
+	tests := []struct {
+		name     string
+		event    string
+		metadata *metav1.ObjectMeta
+		want     bool
+	}{
+		{"DeletedEventWithFinalizer", k8s.EventDeleted, &metav1.ObjectMeta{Finalizers: []string{finalizerGoogleCloudDNS}}, true},
+		{"DeletedEventWithoutFinalizer", k8s.EventDeleted, &metav1.ObjectMeta{}, false},
+		{"DeletedEventWhileBeingDeleted", k8s.EventDeleted, &metav1.ObjectMeta{DeletionTimestamp: deletionTimestamp, Finalizers: []string{finalizerGoogleCloudDNS}}, false},
+		{"ModifiedEventWithFinalizer", k8s.EventModified, &metav1.ObjectMeta{Finalizers: []string{finalizerGoogleCloudDNS}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasLeftLabelSelector(tt.event, tt.metadata); got != tt.want {
+				t.Errorf("hasLeftLabelSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
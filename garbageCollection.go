@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// orphanedSince holds when each record was first found without a claimant, so it's only deleted after the grace period; it's only
+// used by the garbage collection loop and starts empty after a restart or a change of leader, which only delays deletion
+var orphanedSince = map[string]time.Time{}
+
+// collectOrphanedRecords deletes the records in the zone owned by this controller instance that are no longer claimed by a live
+// object, once they have been unclaimed for longer than the grace period; in dry-run mode they're only reported
+func collectOrphanedRecords(dnsService *GoogleCloudDNSService, client *k8s.Client) {
+
+	log.Info().Msg("Collecting orphaned dns records...")
+
+	// without an owner id of its own, the records of other clusters sharing the zone look like records of this one whose objects are
+	// gone, so they're only reported
+	dryRun := *gcDryRun || *ownerID == defaultOwnerID
+
+	owners, err := dnsService.GetDNSRecordOwners()
+	if err != nil {
+		log.Error().Err(err).Msg("Listing owner records for garbage collection failed")
+		return
+	}
+
+	orphaned := map[string]time.Time{}
+	for hostname, ownerValue := range owners {
+		owner, kind, namespace, name, ok := parseOwnerRecordValue(ownerValue)
+		if !ok || owner != *ownerID {
+			continue
+		}
+
+		claimed, err := isHostnameClaimed(client, kind, namespace, name, hostname)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Checking whether %v %v.%v still claims dns record %v failed, skipping", kind, name, namespace, hostname)
+			continue
+		}
+		if claimed {
+			continue
+		}
+
+		since, ok := orphanedSince[hostname]
+		if !ok {
+			since = time.Now()
+		}
+		orphaned[hostname] = since
+
+		if time.Since(since) < *gcGracePeriod {
+			log.Info().Msgf("Dns record %v of %v %v.%v is orphaned since %v, waiting for the grace period of %v to pass", hostname, kind, name, namespace, since.Format(time.RFC3339), *gcGracePeriod)
+			continue
+		}
+
+		if dryRun {
+			log.Info().Msgf("Dns record %v of %v %v.%v is orphaned since %v, it would be deleted if dry-run was disabled and --owner-id was set", hostname, kind, name, namespace, since.Format(time.RFC3339))
+			orphanedRecordsTotals.With(prometheus.Labels{"status": "dry-run"}).Inc()
+			continue
+		}
+
+		// only the types of records the owner record marks are deleted, records of other types at the same name aren't the controller's
+		recordTypes := getOwnerRecordTypes(ownerValue)
+
+		log.Info().Msgf("Deleting dns record %v (%v) of %v %v.%v, it's orphaned since %v...", hostname, strings.Join(recordTypes, ", "), kind, name, namespace, since.Format(time.RFC3339))
+
+		_, _, err = dnsService.DeleteDNSRecords(recordTypes, hostname, ownerValue)
+		if err != nil {
+			log.Error().Err(err).Msgf("Deleting orphaned dns record %v failed", hostname)
+			orphanedRecordsTotals.With(prometheus.Labels{"status": "failed"}).Inc()
+			continue
+		}
+
+		orphanedRecordsTotals.With(prometheus.Labels{"status": "deleted"}).Inc()
+		delete(orphaned, hostname)
+	}
+
+	// records that got claimed again or have been deleted are forgotten
+	orphanedSince = orphaned
+
+	log.Info().Msgf("Found %v orphaned dns records", len(orphanedSince))
+}
+
+// isHostnameClaimed returns true if the object that owns the record of a hostname still exists, has dns enabled and has a synced
+// record for the hostname in its state; hostnames that are in conflict, denied or invalid don't claim the record
+func isHostnameClaimed(client *k8s.Client, kind, namespace, name, hostname string) (bool, error) {
+
+	metadata, known, err := getOwningObjectMetadata(client, kind, namespace, name)
+	if err != nil {
+		return false, err
+	}
+	if !known {
+		// the kind is unknown to this version of the controller, so it's left alone
+		return true, nil
+	}
+
+	return claimsHostname(client, kind, metadata, hostname)
+}
+
+// getOwningObjectMetadata retrieves the metadata of the object an owner record refers to; it's nil if the object doesn't exist and
+// known is false if the kind isn't one the controller sets records for
+func getOwningObjectMetadata(client *k8s.Client, kind, namespace, name string) (metadata *metav1.ObjectMeta, known bool, err error) {
+
+	switch kind {
+	case serviceKind.kind:
+		var service corev1.Service
+		err = client.Get(context.Background(), namespace, name, &service)
+		metadata = service.Metadata
+
+	case ingressKind.kind:
+		resource := newIngressResource()
+		err = client.Get(context.Background(), namespace, name, resource)
+		metadata = resource.GetMetadata()
+
+	case gatewayKind.kind:
+		var gateway Gateway
+		err = client.Get(context.Background(), namespace, name, &gateway)
+		metadata = gateway.Metadata
+
+	case dnsEndpointKind.kind:
+		var endpoint DNSEndpoint
+		err = client.Get(context.Background(), namespace, name, &endpoint)
+		metadata = endpoint.Metadata
+
+	case nodeKind.kind:
+		var node corev1.Node
+		err = client.Get(context.Background(), k8s.AllNamespaces, name, &node)
+		metadata = node.Metadata
+
+	default:
+		return nil, false, nil
+	}
+
+	if isAPIError(err, http.StatusNotFound) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+
+	return metadata, true, nil
+}
+
+// claimsHostname returns true if the object has dns enabled and has a synced record for the hostname in its state; dns endpoints
+// don't need the annotation and nodes can be selected by label instead
+func claimsHostname(client *k8s.Client, kind string, metadata *metav1.ObjectMeta, hostname string) (bool, error) {
+	if metadata == nil || kind != dnsEndpointKind.kind && kind != nodeKind.kind && metadata.Annotations[annotationGoogleCloudDNS] != "true" {
+		return false, nil
+	}
+
+	state, err := getStoredState(client, kind, metadata)
+	if err != nil {
+		return false, err
+	}
+
+	return foundation.StringArrayContains(getSyncedHostnames(state), hostname), nil
+}
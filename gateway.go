@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	foundation "github.com/estafette/estafette-foundation"
+)
+
+const gatewayAPIGroup string = "gateway.networking.k8s.io"
+
+// Gateway represents a gateway.networking.k8s.io/v1 gateway; only the fields needed to derive dns records are decoded
+type Gateway struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Metadata   *metav1.ObjectMeta `json:"metadata"`
+	Spec       GatewaySpec        `json:"spec"`
+	Status     GatewayStatus      `json:"status"`
+}
+
+// GetMetadata returns the metadata of the gateway, required to implement k8s.Resource
+func (g *Gateway) GetMetadata() *metav1.ObjectMeta {
+	return g.Metadata
+}
+
+// GatewayList represents a list of gateways
+type GatewayList struct {
+	Metadata *metav1.ListMeta `json:"metadata"`
+	Items    []*Gateway       `json:"items"`
+}
+
+// GetMetadata returns the metadata of the gateway list, required to implement k8s.ResourceList
+func (l *GatewayList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+// GatewaySpec holds the gateway spec fields the controller reads
+type GatewaySpec struct {
+	GatewayClassName string            `json:"gatewayClassName,omitempty"`
+	Listeners        []GatewayListener `json:"listeners,omitempty"`
+}
+
+// GatewayListener represents a listener of a gateway
+type GatewayListener struct {
+	Name     string  `json:"name,omitempty"`
+	Hostname *string `json:"hostname,omitempty"`
+}
+
+// GatewayStatus represents the status of a gateway
+type GatewayStatus struct {
+	Addresses []GatewayStatusAddress `json:"addresses,omitempty"`
+}
+
+// GatewayStatusAddress represents an address assigned to a gateway
+type GatewayStatusAddress struct {
+	Type  *string `json:"type,omitempty"`
+	Value string  `json:"value"`
+}
+
+// HTTPRoute represents a gateway.networking.k8s.io/v1 http route; it's only read to collect the hostnames of the gateways it's attached to
+type HTTPRoute struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Metadata   *metav1.ObjectMeta `json:"metadata"`
+	Spec       HTTPRouteSpec      `json:"spec"`
+}
+
+// GetMetadata returns the metadata of the http route, required to implement k8s.Resource
+func (r *HTTPRoute) GetMetadata() *metav1.ObjectMeta {
+	return r.Metadata
+}
+
+// HTTPRouteList represents a list of http routes
+type HTTPRouteList struct {
+	Metadata *metav1.ListMeta `json:"metadata"`
+	Items    []*HTTPRoute     `json:"items"`
+}
+
+// GetMetadata returns the metadata of the http route list, required to implement k8s.ResourceList
+func (l *HTTPRouteList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+// HTTPRouteSpec represents the spec of an http route
+type HTTPRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+}
+
+// ParentReference represents a reference from a route to the gateway it attaches to
+type ParentReference struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Namespace *string `json:"namespace,omitempty"`
+	Name      string  `json:"name"`
+}
+
+func init() {
+	k8s.Register(gatewayAPIGroup, "v1", "gateways", true, &Gateway{})
+	k8s.RegisterList(gatewayAPIGroup, "v1", "gateways", true, &GatewayList{})
+
+	k8s.Register(gatewayAPIGroup, "v1", "httproutes", true, &HTTPRoute{})
+	k8s.RegisterList(gatewayAPIGroup, "v1", "httproutes", true, &HTTPRouteList{})
+}
+
+// isGateway returns true if the parent reference points to a gateway, rather than another kind of parent
+func (ref ParentReference) isGateway() bool {
+	return (ref.Group == nil || *ref.Group == gatewayAPIGroup) && (ref.Kind == nil || *ref.Kind == "Gateway")
+}
+
+// gatewayNamespace returns the namespace of the referenced gateway, which defaults to the namespace of the route
+func (ref ParentReference) gatewayNamespace(routeNamespace string) string {
+	if ref.Namespace != nil && *ref.Namespace != "" {
+		return *ref.Namespace
+	}
+	return routeNamespace
+}
+
+// refersToGateway returns true if the parent reference of a route in routeNamespace points to the gateway
+func (ref ParentReference) refersToGateway(routeNamespace, gatewayNamespace, gatewayName string) bool {
+	return ref.isGateway() && ref.gatewayNamespace(routeNamespace) == gatewayNamespace && ref.Name == gatewayName
+}
+
+// getAttachedHTTPRoutes lists all http routes that have the gateway as one of their parents; the --label-selector applies to the gateway,
+// so the routes don't need its labels
+func getAttachedHTTPRoutes(client *k8s.Client, gateway *Gateway) (routes []*HTTPRoute, err error) {
+
+	for _, namespace := range watchNamespaces() {
+		var httpRoutes HTTPRouteList
+		err = client.List(context.Background(), namespace, &httpRoutes, httpRouteKind.listOptions()...)
+		if err != nil {
+			return
+		}
+
+		for _, route := range httpRoutes.Items {
+			for _, ref := range route.Spec.ParentRefs {
+				if ref.refersToGateway(*route.Metadata.Namespace, *gateway.Metadata.Namespace, *gateway.Metadata.Name) {
+					routes = append(routes, route)
+					break
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func getDesiredGatewayState(gateway *Gateway, routes []*HTTPRoute) (state GoogleCloudDNSState, err error) {
+
+	var ok bool
+
+	state.Enabled, ok = gateway.Metadata.Annotations[annotationGoogleCloudDNS]
+	if !ok {
+		state.Enabled = "false"
+	}
+
+	// collect hostnames from the annotation, the listeners and the attached http routes
+	hostnames := []string{}
+	if annotationHostnames, ok := gateway.Metadata.Annotations[annotationGoogleCloudDNSHostnames]; ok && annotationHostnames != "" {
+		hostnames = append(hostnames, strings.Split(annotationHostnames, ",")...)
+	}
+	for _, listener := range gateway.Spec.Listeners {
+		// wildcard listeners can't be published as a single record
+		if listener.Hostname != nil && !strings.HasPrefix(*listener.Hostname, "*") {
+			hostnames = append(hostnames, *listener.Hostname)
+		}
+	}
+	for _, route := range routes {
+		for _, hostname := range route.Spec.Hostnames {
+			if !strings.HasPrefix(hostname, "*") {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+	state.Hostnames, err = addTemplatedHostnames(joinHostnames(hostnames), gateway.Metadata)
+	if err != nil {
+		return
+	}
+
+	for _, address := range gateway.Status.Addresses {
+		if address.Type == nil || *address.Type == "IPAddress" {
+			state.IPAddress = address.Value
+			break
+		}
+	}
+
+	return
+}
+
+func getCurrentGatewayState(client *k8s.Client, gateway *Gateway) (state GoogleCloudDNSState, err error) {
+	return getStoredState(client, "Gateway", gateway.Metadata)
+}
+
+func makeGatewayChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, gateway *Gateway, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
+	return makeChanges(dnsService, client, "Gateway", gateway, initiator, desiredState, currentState)
+}
+
+func processGateway(dnsService *GoogleCloudDNSService, client *k8s.Client, gateway *Gateway, initiator string) (status string, err error) {
+
+	status = "failed"
+
+	if gateway != nil && gateway.Metadata != nil && gateway.Metadata.Annotations != nil && (gateway.Metadata.Annotations[annotationGoogleCloudDNS] == "true" || hasFinalizer(gateway.Metadata)) && (isBeingReleased(gateway.Metadata) || isInScope(gateway.Metadata)) {
+
+		routes, err := getAttachedHTTPRoutes(client, gateway)
+		if err != nil {
+			return status, err
+		}
+
+		desiredState, templateErr := getDesiredGatewayState(gateway, routes)
+		currentState, err := getCurrentGatewayState(client, gateway)
+		if err != nil {
+			return status, err
+		}
+		// an object that is being deleted gets its records deleted, whatever its hostname template
+		if templateErr != nil && gateway.Metadata.DeletionTimestamp == nil {
+			return rejectHostnameTemplate(client, "Gateway", gateway, initiator, currentState, templateErr)
+		}
+
+		return makeGatewayChanges(dnsService, client, gateway, initiator, desiredState, currentState)
+	}
+
+	status = "skipped"
+
+	return status, nil
+}
+
+// getHTTPRouteGatewayWorkItems returns the work items for the gateways an http route is attached to, since the route adds
+// hostnames to them
+func getHTTPRouteGatewayWorkItems(route *HTTPRoute, initiator string) (items []workItem) {
+
+	for _, ref := range route.Spec.ParentRefs {
+		// only gateways within the namespaces this controller is scoped to are processed
+		namespace := ref.gatewayNamespace(*route.Metadata.Namespace)
+		if !ref.isGateway() || len(*namespaces) > 0 && !foundation.StringArrayContains(*namespaces, namespace) {
+			continue
+		}
+
+		items = append(items, workItem{
+			Namespace: namespace,
+			Kind:      gatewayKind.kind,
+			Name:      ref.Name,
+			Initiator: initiator,
+		})
+	}
+
+	return
+}
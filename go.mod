@@ -10,6 +10,7 @@ require (
 	github.com/ericchiang/k8s v1.2.0
 	github.com/estafette/estafette-foundation v0.0.52
 	github.com/fsnotify/fsnotify v1.4.7
+	github.com/golang/protobuf v1.2.0
 	github.com/mattn/go-isatty v0.0.6 // indirect
 	github.com/prometheus/client_golang v0.9.2
 	github.com/rs/zerolog v1.17.2
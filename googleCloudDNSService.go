@@ -3,21 +3,31 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/dns/v1"
 )
 
+// dnsRecordTTL is the time to live in seconds of the records set by the controller
+const dnsRecordTTL int64 = 300
+
 // GoogleCloudDNSService is the service that allows to create or update dns records
 type GoogleCloudDNSService struct {
 	service *dns.Service
 	project string
 	zone    string
+	limiter *rateLimiter
+
+	// zoneDNSName caches the dns name of the zone, like example.com.
+	zoneDNSName      string
+	zoneDNSNameMutex *sync.Mutex
 }
 
-// NewGoogleCloudDNSService returns an initialized APIClient
-func NewGoogleCloudDNSService(project, zone string) *GoogleCloudDNSService {
+// NewGoogleCloudDNSService returns an initialized APIClient; the rate limiter is shared between instances so it survives reinitialization
+func NewGoogleCloudDNSService(project, zone string, limiter *rateLimiter) *GoogleCloudDNSService {
 
 	log.Debug().Msgf("Creating new GoogleCloudDNSService for project %v and zone %v", project, zone)
 
@@ -36,9 +46,31 @@ func NewGoogleCloudDNSService(project, zone string) *GoogleCloudDNSService {
 		service: dnsService,
 		project: project,
 		zone:    zone,
+		limiter: limiter,
+
+		zoneDNSNameMutex: &sync.Mutex{},
 	}
 }
 
+// GetZoneDNSName returns the dns name of the zone, like example.com., which is retrieved once
+func (dnsService *GoogleCloudDNSService) GetZoneDNSName() (string, error) {
+	dnsService.zoneDNSNameMutex.Lock()
+	defer dnsService.zoneDNSNameMutex.Unlock()
+
+	if dnsService.zoneDNSName != "" {
+		return dnsService.zoneDNSName, nil
+	}
+
+	dnsService.limiter.Wait()
+	managedZone, err := dnsService.service.ManagedZones.Get(dnsService.project, dnsService.zone).Context(context.Background()).Do()
+	if err != nil {
+		return "", err
+	}
+	dnsService.zoneDNSName = managedZone.DnsName
+
+	return dnsService.zoneDNSName, nil
+}
+
 // GetDNSRecordByName returns the record sets matching name and type
 func (dnsService *GoogleCloudDNSService) GetDNSRecordByName(dnsRecordType, dnsRecordName string) (records []*dns.ResourceRecordSet) {
 
@@ -46,6 +78,7 @@ func (dnsService *GoogleCloudDNSService) GetDNSRecordByName(dnsRecordType, dnsRe
 
 	req := dnsService.service.ResourceRecordSets.List(dnsService.project, dnsService.zone).Name(fmt.Sprintf("%v.", dnsRecordName)).Type(dnsRecordType)
 
+	dnsService.limiter.Wait()
 	err := req.Pages(context.Background(), func(page *dns.ResourceRecordSetsListResponse) error {
 		records = page.Rrsets
 		return nil
@@ -58,39 +91,203 @@ func (dnsService *GoogleCloudDNSService) GetDNSRecordByName(dnsRecordType, dnsRe
 	return
 }
 
-// UpsertDNSRecord either updates or creates a dns record.
-func (dnsService *GoogleCloudDNSService) UpsertDNSRecord(dnsRecordType, dnsRecordName, dnsRecordContent string) (err error) {
+// GetDNSRecordOwner returns the value of the txt record that marks which object owns the records of a hostname, or an empty string
+// if nothing owns them
+func (dnsService *GoogleCloudDNSService) GetDNSRecordOwner(dnsRecordName string) (owner string, err error) {
+
+	req := dnsService.service.ResourceRecordSets.List(dnsService.project, dnsService.zone).Name(fmt.Sprintf("%v.", ownerRecordName(dnsRecordName))).Type("TXT")
+
+	dnsService.limiter.Wait()
+	err = req.Pages(context.Background(), func(page *dns.ResourceRecordSetsListResponse) error {
+		for _, record := range page.Rrsets {
+			if len(record.Rrdatas) > 0 {
+				owner = strings.Trim(record.Rrdatas[0], "\"")
+			}
+		}
+		return nil
+	})
+
+	return
+}
+
+// GetDNSRecordOwners returns the values of all txt records in the zone that mark the owner of a record, by the hostname of the record
+func (dnsService *GoogleCloudDNSService) GetDNSRecordOwners() (owners map[string]string, err error) {
+
+	owners = map[string]string{}
+
+	req := dnsService.service.ResourceRecordSets.List(dnsService.project, dnsService.zone)
+
+	dnsService.limiter.Wait()
+	err = req.Pages(context.Background(), func(page *dns.ResourceRecordSetsListResponse) error {
+		for _, record := range page.Rrsets {
+			if record.Type == "TXT" && strings.HasPrefix(record.Name, ownerRecordPrefix) && len(record.Rrdatas) > 0 {
+				hostname := strings.TrimSuffix(strings.TrimPrefix(record.Name, ownerRecordPrefix), ".")
+				owners[hostname] = strings.Trim(record.Rrdatas[0], "\"")
+			}
+		}
+		// every next page counts as another request
+		dnsService.limiter.Wait()
+		return nil
+	})
+
+	return
+}
+
+// UpsertDNSRecordSet either updates or creates a dns record with one or more values and a custom time to live, together with the txt
+// record marking the owner of the record; it returns true if the record didn't exist before and the id of the cloud dns change
+func (dnsService *GoogleCloudDNSService) UpsertDNSRecordSet(dnsRecordType, dnsRecordName string, ttl int64, dnsRecordContents []string, owner string) (created bool, changeID string, err error) {
 
 	// retrieve records in case they exist
 	records := dnsService.GetDNSRecordByName(dnsRecordType, dnsRecordName)
+	ownerRecords := dnsService.GetDNSRecordByName("TXT", ownerRecordName(dnsRecordName))
+
+	currentOwner := ""
+	for _, record := range ownerRecords {
+		if len(record.Rrdatas) > 0 {
+			currentOwner = strings.Trim(record.Rrdatas[0], "\"")
+		}
+	}
+
+	change := dns.Change{
+		Additions: []*dns.ResourceRecordSet{
+			&dns.ResourceRecordSet{
+				Name:             fmt.Sprintf("%v.", dnsRecordName),
+				Type:             dnsRecordType,
+				Ttl:              ttl,
+				Rrdatas:          dnsRecordContents,
+				SignatureRrdatas: []string{},
+				Kind:             "dns#resourceRecordSet",
+			},
+			&dns.ResourceRecordSet{
+				Name: fmt.Sprintf("%v.", ownerRecordName(dnsRecordName)),
+				Type: "TXT",
+				Ttl:  dnsRecordTTL,
+				Rrdatas: []string{
+					fmt.Sprintf("\"%v\"", addOwnerRecordType(currentOwner, owner, dnsRecordType)),
+				},
+				SignatureRrdatas: []string{},
+				Kind:             "dns#resourceRecordSet",
+			},
+		},
+	}
+
+	// updating a record is done by deleting the current ones and adding the new one
+	change.Deletions = append(records, ownerRecords...)
+	created = len(records) == 0
+
+	dnsService.limiter.Wait()
+	resp, err := dnsService.service.Changes.Create(dnsService.project, dnsService.zone, &change).Context(context.Background()).Do()
+
+	if err != nil {
+		return created, changeID, err
+	}
+
+	log.Debug().Interface("response", resp).Msgf("Response from google cloud dns api")
+
+	return created, resp.Id, nil
+}
+
+// UpsertDNSRecordOwner writes the txt record marking the owner of an existing dns record, for records set by an older version of the
+// controller that didn't write one; it returns false if the record is owned by someone else and the id of the cloud dns change
+func (dnsService *GoogleCloudDNSService) UpsertDNSRecordOwner(dnsRecordType, dnsRecordName, owner string) (owned bool, changeID string, err error) {
+
+	ownerRecords := dnsService.GetDNSRecordByName("TXT", ownerRecordName(dnsRecordName))
+
+	currentOwner := ""
+	for _, record := range ownerRecords {
+		if len(record.Rrdatas) > 0 {
+			currentOwner = strings.Trim(record.Rrdatas[0], "\"")
+		}
+	}
+
+	value, owned := backfillOwnerRecordType(currentOwner, owner, dnsRecordType)
+	if !owned || value == currentOwner {
+		return owned, changeID, nil
+	}
 
 	change := dns.Change{
 		Additions: []*dns.ResourceRecordSet{
 			&dns.ResourceRecordSet{
-				Name: fmt.Sprintf("%v.", dnsRecordName),
-				Type: dnsRecordType,
-				Ttl:  300,
+				Name: fmt.Sprintf("%v.", ownerRecordName(dnsRecordName)),
+				Type: "TXT",
+				Ttl:  dnsRecordTTL,
 				Rrdatas: []string{
-					dnsRecordContent,
+					fmt.Sprintf("\"%v\"", value),
 				},
 				SignatureRrdatas: []string{},
 				Kind:             "dns#resourceRecordSet",
 			},
 		},
+		Deletions: ownerRecords,
 	}
 
-	if len(records) > 0 {
-		// updating a record is done by deleting the current ones and adding the new one
-		change.Deletions = records
+	dnsService.limiter.Wait()
+	resp, err := dnsService.service.Changes.Create(dnsService.project, dnsService.zone, &change).Context(context.Background()).Do()
+
+	if err != nil {
+		return owned, changeID, err
+	}
+
+	log.Debug().Interface("response", resp).Msgf("Response from google cloud dns api")
+
+	return owned, resp.Id, nil
+}
+
+// DeleteDNSRecord deletes a dns record together with the txt record marking its owner, unless the owner record shows it's owned by
+// someone else; it returns false if there was nothing to delete and the id of the cloud dns change
+func (dnsService *GoogleCloudDNSService) DeleteDNSRecord(dnsRecordType, dnsRecordName, owner string) (deleted bool, changeID string, err error) {
+	return dnsService.DeleteDNSRecords([]string{dnsRecordType}, dnsRecordName, owner)
+}
+
+// DeleteDNSRecords deletes the dns records of the given types of a name together with the txt record marking their owner in a single
+// change, unless the owner record shows they're owned by someone else; records of other types are left alone
+func (dnsService *GoogleCloudDNSService) DeleteDNSRecords(dnsRecordTypes []string, dnsRecordName, owner string) (deleted bool, changeID string, err error) {
+
+	currentOwner, err := dnsService.GetDNSRecordOwner(dnsRecordName)
+	if err != nil {
+		return false, changeID, err
+	}
+	if isOwnershipConflict(currentOwner, owner) {
+		log.Debug().Msgf("Dns record %v is owned by %v, not deleting it", dnsRecordName, currentOwner)
+		return false, changeID, nil
+	}
+
+	change := dns.Change{}
+	for _, dnsRecordType := range dnsRecordTypes {
+		change.Deletions = append(change.Deletions, dnsService.GetDNSRecordByName(dnsRecordType, dnsRecordName)...)
 	}
 
+	// the owner record is kept for the types of records that are left, so they can still be garbage collected
+	ownerRecords := dnsService.GetDNSRecordByName("TXT", ownerRecordName(dnsRecordName))
+	remainingTypes := removeOwnerRecordTypes(currentOwner, dnsRecordTypes)
+	if currentOwner != "" && len(remainingTypes) > 0 {
+		if len(change.Deletions) == 0 {
+			return false, changeID, nil
+		}
+		change.Additions = append(change.Additions, &dns.ResourceRecordSet{
+			Name: fmt.Sprintf("%v.", ownerRecordName(dnsRecordName)),
+			Type: "TXT",
+			Ttl:  dnsRecordTTL,
+			Rrdatas: []string{
+				fmt.Sprintf("\"%v\"", withOwnerRecordTypes(currentOwner, remainingTypes)),
+			},
+			SignatureRrdatas: []string{},
+			Kind:             "dns#resourceRecordSet",
+		})
+	}
+	change.Deletions = append(change.Deletions, ownerRecords...)
+	if len(change.Deletions) == 0 {
+		return false, changeID, nil
+	}
+
+	dnsService.limiter.Wait()
 	resp, err := dnsService.service.Changes.Create(dnsService.project, dnsService.zone, &change).Context(context.Background()).Do()
 
 	if err != nil {
-		return err
+		return false, changeID, err
 	}
 
 	log.Debug().Interface("response", resp).Msgf("Response from google cloud dns api")
 
-	return
+	return true, resp.Id, nil
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// endpointSliceServiceNameLabel is the label kubernetes sets on an endpoint slice to tell which service it belongs to
+const endpointSliceServiceNameLabel string = "kubernetes.io/service-name"
+
+// EndpointSlice represents a discovery.k8s.io/v1 endpoint slice; the kubernetes client doesn't include the discovery api, so only the
+// fields needed to derive dns records are decoded
+type EndpointSlice struct {
+	Kind        string                  `json:"kind,omitempty"`
+	APIVersion  string                  `json:"apiVersion,omitempty"`
+	Metadata    *metav1.ObjectMeta      `json:"metadata"`
+	AddressType string                  `json:"addressType"`
+	Endpoints   []EndpointSliceEndpoint `json:"endpoints"`
+}
+
+// GetMetadata returns the metadata of the endpoint slice, required to implement k8s.Resource
+func (s *EndpointSlice) GetMetadata() *metav1.ObjectMeta {
+	return s.Metadata
+}
+
+// EndpointSliceList represents a list of endpoint slices
+type EndpointSliceList struct {
+	Metadata *metav1.ListMeta `json:"metadata"`
+	Items    []*EndpointSlice `json:"items"`
+}
+
+// GetMetadata returns the metadata of the endpoint slice list, required to implement k8s.ResourceList
+func (l *EndpointSliceList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+// EndpointSliceEndpoint represents an endpoint of an endpoint slice, usually a single pod
+type EndpointSliceEndpoint struct {
+	Addresses  []string                `json:"addresses"`
+	Conditions EndpointSliceConditions `json:"conditions"`
+	Hostname   *string                 `json:"hostname,omitempty"`
+	TargetRef  *EndpointSliceTargetRef `json:"targetRef,omitempty"`
+}
+
+// EndpointSliceConditions represents the conditions of an endpoint; a missing ready condition means the endpoint is ready
+type EndpointSliceConditions struct {
+	Ready *bool `json:"ready,omitempty"`
+}
+
+// EndpointSliceTargetRef refers to the object behind an endpoint
+type EndpointSliceTargetRef struct {
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+func init() {
+	k8s.Register("discovery.k8s.io", "v1", "endpointslices", true, &EndpointSlice{})
+	k8s.RegisterList("discovery.k8s.io", "v1", "endpointslices", true, &EndpointSliceList{})
+}
+
+// isHeadlessService returns true for a service without a cluster ip, whose endpoints are addressed directly
+func isHeadlessService(service *corev1.Service) bool {
+	serviceType := service.GetSpec().GetType()
+	return (serviceType == "" || serviceType == "ClusterIP") && service.GetSpec().GetClusterIP() == "None"
+}
+
+// getEndpointSliceServiceWorkItem returns the work item for the service an endpoint slice belongs to
+func getEndpointSliceServiceWorkItem(slice *EndpointSlice, initiator string) (item workItem, ok bool) {
+	name, ok := slice.Metadata.Labels[endpointSliceServiceNameLabel]
+	if !ok || name == "" {
+		return item, false
+	}
+
+	return workItem{
+		Namespace: slice.Metadata.GetNamespace(),
+		Kind:      serviceKind.kind,
+		Name:      name,
+		Initiator: initiator,
+	}, true
+}
+
+// getServiceEndpointSlices returns the endpoint slices kubernetes maintains for a service
+func getServiceEndpointSlices(client *k8s.Client, service *corev1.Service) ([]*EndpointSlice, error) {
+
+	var slices EndpointSliceList
+	selector := new(k8s.LabelSelector)
+	selector.Eq(endpointSliceServiceNameLabel, service.Metadata.GetName())
+	err := client.List(context.Background(), service.Metadata.GetNamespace(), &slices, selector.Selector())
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.Items, nil
+}
+
+// isEndpointReady returns true if an endpoint is ready; a missing ready condition means it's ready
+func isEndpointReady(endpoint EndpointSliceEndpoint) bool {
+	return endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+}
+
+// makeHeadlessServiceChanges sets a record for each hostname of a headless service pointing to all of its ready endpoints, and a
+// record per ready endpoint with a hostname, so pod-0.db.example.com points to pod-0 for hostname db.example.com; the records of
+// endpoints that are gone are deleted
+func makeHeadlessServiceChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, service *corev1.Service, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
+
+	slices, err := getServiceEndpointSlices(client, service)
+	if err != nil {
+		return "failed", err
+	}
+
+	records := getHeadlessServiceRecords(strings.Split(desiredState.Hostnames, ","), slices)
+
+	// the records of a headless service each have their own targets, the ones of the service as a whole don't apply
+	currentState.IPAddress = ""
+	currentState.RecordType = ""
+	currentState.Target = ""
+
+	return makeRecordSetChanges(dnsService, client, "Service", service, initiator, records, currentState, true)
+}
+
+// getHeadlessServiceRecords returns the A and AAAA records for the ready endpoints of a headless service, sorted by name and type
+func getHeadlessServiceRecords(hostnames []string, slices []*EndpointSlice) (records []DNSEndpointRecord) {
+
+	targets := map[string][]string{}
+	add := func(name, recordType, address string) {
+		key := dnsEndpointRecordKey(name, recordType)
+		targets[key] = appendUnique(targets[key], address)
+	}
+
+	for _, slice := range slices {
+		recordType := ""
+		switch slice.AddressType {
+		case "IPv4":
+			recordType = "A"
+		case "IPv6":
+			recordType = "AAAA"
+		default:
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			if !isEndpointReady(endpoint) {
+				continue
+			}
+
+			podHostname := ""
+			if endpoint.Hostname != nil {
+				podHostname = *endpoint.Hostname
+			} else if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				podHostname = endpoint.TargetRef.Name
+			}
+
+			for _, address := range endpoint.Addresses {
+				for _, hostname := range hostnames {
+					hostname = strings.TrimSpace(hostname)
+					if hostname == "" {
+						continue
+					}
+					add(hostname, recordType, address)
+					if podHostname != "" {
+						add(fmt.Sprintf("%v.%v", podHostname, hostname), recordType, address)
+					}
+				}
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		separator := strings.LastIndex(key, "/")
+		recordTargets := targets[key]
+		sort.Strings(recordTargets)
+
+		records = append(records, DNSEndpointRecord{
+			Name:    key[:separator],
+			Type:    key[separator+1:],
+			TTL:     dnsRecordTTL,
+			Targets: recordTargets,
+		})
+	}
+
+	return
+}
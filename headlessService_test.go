@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetHeadlessServiceRecords(t *testing.T) {
+
+	ready := true
+	notReady := false
+	podHostname := "db-0"
+
+	tests := []struct {
+		name      string
+		hostnames []string
+		slices    []*EndpointSlice
+		want      []DNSEndpointRecord
+	}{
+		{
+			name:      "no slices",
+			hostnames: []string{"db.example.com"},
+			slices:    []*EndpointSlice{},
+			want:      nil,
+		},
+		{
+			name:      "endpoint hostname gets its own record",
+			hostnames: []string{"db.example.com"},
+			slices: []*EndpointSlice{{
+				AddressType: "IPv4",
+				Endpoints: []EndpointSliceEndpoint{
+					{Addresses: []string{"10.0.0.2"}, Conditions: EndpointSliceConditions{Ready: &ready}, Hostname: &podHostname},
+				},
+			}},
+			want: []DNSEndpointRecord{
+				{Name: "db-0.db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.2"}},
+				{Name: "db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.2"}},
+			},
+		},
+		{
+			name:      "pod name is used without an endpoint hostname",
+			hostnames: []string{"db.example.com"},
+			slices: []*EndpointSlice{{
+				AddressType: "IPv4",
+				Endpoints: []EndpointSliceEndpoint{
+					{Addresses: []string{"10.0.0.3"}, TargetRef: &EndpointSliceTargetRef{Kind: "Pod", Name: "db-abc"}},
+				},
+			}},
+			want: []DNSEndpointRecord{
+				{Name: "db-abc.db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.3"}},
+				{Name: "db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.3"}},
+			},
+		},
+		{
+			name:      "unready endpoints are left out and targets are sorted",
+			hostnames: []string{"db.example.com"},
+			slices: []*EndpointSlice{{
+				AddressType: "IPv4",
+				Endpoints: []EndpointSliceEndpoint{
+					{Addresses: []string{"10.0.0.9"}},
+					{Addresses: []string{"10.0.0.5"}, Conditions: EndpointSliceConditions{Ready: &notReady}},
+					{Addresses: []string{"10.0.0.1"}, Conditions: EndpointSliceConditions{Ready: &ready}},
+				},
+			}},
+			want: []DNSEndpointRecord{
+				{Name: "db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.1", "10.0.0.9"}},
+			},
+		},
+		{
+			name:      "ipv6 slices give aaaa records and fqdn slices are ignored",
+			hostnames: []string{"db.example.com"},
+			slices: []*EndpointSlice{
+				{AddressType: "IPv6", Endpoints: []EndpointSliceEndpoint{{Addresses: []string{"2001:db8::1"}}}},
+				{AddressType: "IPv4", Endpoints: []EndpointSliceEndpoint{{Addresses: []string{"10.0.0.1"}}}},
+				{AddressType: "FQDN", Endpoints: []EndpointSliceEndpoint{{Addresses: []string{"db.internal"}}}},
+			},
+			want: []DNSEndpointRecord{
+				{Name: "db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.1"}},
+				{Name: "db.example.com", Type: "AAAA", TTL: dnsRecordTTL, Targets: []string{"2001:db8::1"}},
+			},
+		},
+		{
+			name:      "every hostname gets the records and empty hostnames are skipped",
+			hostnames: []string{"db.example.com", " ", "db.example.org"},
+			slices: []*EndpointSlice{{
+				AddressType: "IPv4",
+				Endpoints:   []EndpointSliceEndpoint{{Addresses: []string{"10.0.0.1"}}},
+			}},
+			want: []DNSEndpointRecord{
+				{Name: "db.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.1"}},
+				{Name: "db.example.org", Type: "A", TTL: dnsRecordTTL, Targets: []string{"10.0.0.1"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getHeadlessServiceRecords(tt.hostnames, tt.slices)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHeadlessServiceRecords() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
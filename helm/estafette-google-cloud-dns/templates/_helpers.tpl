@@ -63,4 +63,123 @@ Create the tag of the image to use
 */}}
 {{- define "estafette-google-cloud-dns.imageTag" -}}
 {{ default .Chart.AppVersion .Values.image.tag }}
-{{- end -}}
\ No newline at end of file
+{{- end -}}
+
+{{/*
+Create the rbac rules the controller needs, used for either the cluster role or the namespaced roles
+*/}}
+{{- define "estafette-google-cloud-dns.rbacRules" -}}
+- apiGroups: [""] # "" indicates the core API group
+  resources:
+  - services
+  verbs:
+  - get
+  - list
+  - watch
+  - patch
+- apiGroups: [""]
+  resources:
+  - events
+  verbs:
+  - create
+- apiGroups: ["networking.k8s.io", "extensions"]
+  resources:
+  - ingresses
+  verbs:
+  - get
+  - list
+  - watch
+  - patch
+{{- if eq .Values.stateStore "crd" }}
+- apiGroups: ["dns.estafette.io"]
+  resources:
+  - dnsrecordstates
+  verbs:
+  - get
+  - create
+  - update
+{{- end }}
+{{- if or .Values.enableHeadlessServices .Values.enableReadyEndpoints }}
+- apiGroups: ["discovery.k8s.io"]
+  resources:
+  - endpointslices
+  verbs:
+  - list
+  - watch
+{{- end }}
+{{- if .Values.enableDNSEndpoints }}
+- apiGroups: ["dns.estafette.io"]
+  resources:
+  - dnsendpoints
+  verbs:
+  - get
+  - list
+  - watch
+  - patch
+- apiGroups: ["dns.estafette.io"]
+  resources:
+  - dnsendpoints/status
+  verbs:
+  - update
+{{- end }}
+{{- if .Values.enableGatewayAPI }}
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources:
+  - gateways
+  verbs:
+  - get
+  - list
+  - watch
+  - patch
+- apiGroups: ["gateway.networking.k8s.io"]
+  resources:
+  - httproutes
+  verbs:
+  - list
+  - watch
+{{- end }}
+{{- end -}}
+
+{{/*
+Whether services can use the nodeExternalIPs address source; unless set it's disabled when the controller is limited to namespaces, since
+listing and watching the nodes requires a cluster role
+*/}}
+{{- define "estafette-google-cloud-dns.enableNodeExternalIPs" -}}
+{{- if kindIs "bool" .Values.enableNodeExternalIPs -}}
+{{- .Values.enableNodeExternalIPs -}}
+{{- else -}}
+{{- not .Values.namespaces -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+Create the rbac rules for cluster-scoped resources; namespaced roles can't grant those, so they're always granted by the cluster role
+*/}}
+{{- define "estafette-google-cloud-dns.clusterRbacRules" -}}
+{{- if or .Values.enableNodes (eq (include "estafette-google-cloud-dns.enableNodeExternalIPs" .) "true") }}
+- apiGroups: [""]
+  resources:
+  - nodes
+  verbs:
+  - list
+  - watch
+{{- if .Values.enableNodes }}
+  - get
+  - patch
+{{- end }}
+{{- end }}
+{{- if and .Values.enableNodes .Values.namespaces }}
+- apiGroups: [""] # the events of nodes are recorded in the default namespace
+  resources:
+  - events
+  verbs:
+  - create
+{{- end }}
+{{- if or .Values.domainPolicy.rules .Values.domainPolicyConfigMap }}
+- apiGroups: [""]
+  resources:
+  - namespaces
+  verbs:
+  - get
+{{- end }}
+{{- end -}}
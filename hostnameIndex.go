@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// annotationGoogleCloudDNSPriority decides which object gets a hostname claimed by multiple objects; the highest priority wins and
+// on equal priority the object that was created first
+const annotationGoogleCloudDNSPriority string = "estafette.io/google-cloud-dns-priority"
+
+// hostnameClaim is the claim of an object on a hostname
+type hostnameClaim struct {
+	item     workItem
+	priority int
+	// created is the creation timestamp of the object in seconds, so the rule for equal priorities doesn't depend on the order
+	// objects happen to be processed in
+	created int64
+}
+
+// wins returns true if the claim takes precedence over the other claim
+func (claim hostnameClaim) wins(other hostnameClaim) bool {
+	if claim.priority != other.priority {
+		return claim.priority > other.priority
+	}
+	if claim.created != other.created {
+		return claim.created < other.created
+	}
+	return claim.item.Key() < other.item.Key()
+}
+
+// hostnameIndex keeps track of which objects claim which hostnames, so a hostname claimed by multiple objects is only published for
+// one of them instead of flipping between their ip addresses
+type hostnameIndex struct {
+	mutex *sync.Mutex
+	// claims holds the claims per object key per hostname
+	claims map[string]map[string]hostnameClaim
+	// onWinnerChange is called for an object that gains or loses a hostname because another object claims it or gives it up, so
+	// it gets processed again
+	onWinnerChange func(item workItem)
+}
+
+// hostnameClaims is the index of all hostnames claimed by the processed objects
+var hostnameClaims = newHostnameIndex()
+
+func newHostnameIndex() *hostnameIndex {
+	return &hostnameIndex{
+		mutex:  &sync.Mutex{},
+		claims: map[string]map[string]hostnameClaim{},
+	}
+}
+
+// newHostnameClaim returns the claim of an object of a kind, with the priority from its annotation
+func newHostnameClaim(kind string, metadata *metav1.ObjectMeta) hostnameClaim {
+	claim := hostnameClaim{
+		item: workItem{
+			Namespace: metadata.GetNamespace(),
+			Kind:      kind,
+			Name:      metadata.GetName(),
+		},
+	}
+	if metadata.CreationTimestamp != nil {
+		claim.created = metadata.CreationTimestamp.GetSeconds()
+	}
+	if value, ok := metadata.Annotations[annotationGoogleCloudDNSPriority]; ok {
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			log.Warn().Err(err).Msgf("%v %v.%v - Invalid value %v for the %v annotation, using priority 0", kind, metadata.GetName(), metadata.GetNamespace(), value, annotationGoogleCloudDNSPriority)
+		}
+		claim.priority = priority
+	}
+	return claim
+}
+
+// Claim replaces the hostnames claimed by an object and returns the winning claim for each of the hostnames won by another object
+func (index *hostnameIndex) Claim(claim hostnameClaim, hostnames []string) (lost map[string]hostnameClaim) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	claimed := map[string]bool{}
+	for _, hostname := range hostnames {
+		claimed[hostname] = true
+	}
+	index.release(claim.item.Key(), claimed)
+
+	lost = map[string]hostnameClaim{}
+	for hostname := range claimed {
+		if index.claims[hostname] == nil {
+			index.claims[hostname] = map[string]hostnameClaim{}
+		}
+		previous, hadClaims := index.winner(hostname), len(index.claims[hostname]) > 0
+		index.claims[hostname][claim.item.Key()] = claim
+
+		winner := index.winner(hostname)
+		if winner.item.Key() != claim.item.Key() {
+			lost[hostname] = winner
+			if hadClaims && previous.item.Key() == claim.item.Key() && index.onWinnerChange != nil {
+				log.Info().Msgf("Hostname %v is handed over to %v %v.%v", hostname, winner.item.Kind, winner.item.Name, winner.item.Namespace)
+				index.onWinnerChange(winner.item)
+			}
+		} else if hadClaims && previous.item.Key() != claim.item.Key() && index.onWinnerChange != nil {
+			log.Info().Msgf("Hostname %v is taken over from %v %v.%v", hostname, previous.item.Kind, previous.item.Name, previous.item.Namespace)
+			index.onWinnerChange(previous.item)
+		}
+	}
+
+	return
+}
+
+// Release removes all hostnames claimed by an object
+func (index *hostnameIndex) Release(item workItem) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.release(item.Key(), map[string]bool{})
+}
+
+// release removes the claims of an object on the hostnames it no longer claims and hands them over to the next claimant; the mutex
+// has to be held
+func (index *hostnameIndex) release(key string, keep map[string]bool) {
+	for hostname, claims := range index.claims {
+		if _, ok := claims[key]; !ok || keep[hostname] {
+			continue
+		}
+
+		wasWinner := index.winner(hostname).item.Key() == key
+		delete(claims, key)
+		if len(claims) == 0 {
+			delete(index.claims, hostname)
+			continue
+		}
+
+		if wasWinner && index.onWinnerChange != nil {
+			next := index.winner(hostname)
+			log.Info().Msgf("Hostname %v is handed over to %v %v.%v", hostname, next.item.Kind, next.item.Name, next.item.Namespace)
+			index.onWinnerChange(next.item)
+		}
+	}
+}
+
+// winner returns the claim that takes precedence for a hostname; the mutex has to be held
+func (index *hostnameIndex) winner(hostname string) (winner hostnameClaim) {
+	first := true
+	for _, claim := range index.claims[hostname] {
+		if first || claim.wins(winner) {
+			winner = claim
+			first = false
+		}
+	}
+	return
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestHostnameIndex(t *testing.T) {
+
+	claim := func(name string, priority int, created int64) hostnameClaim {
+		return hostnameClaim{item: workItem{Namespace: "default", Kind: "service", Name: name}, priority: priority, created: created}
+	}
+	older := claim("older", 0, 100)
+	newer := claim("newer", 0, 200)
+	preferred := claim("preferred", 10, 300)
+
+	// step either claims the hostnames for an object, or releases all of its hostnames if hostnames is nil
+	type step struct {
+		claim     hostnameClaim
+		hostnames []string
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+		// wantLost is the hostnames lost by the object of the last step, with the name of the object winning them
+		wantLost map[string]string
+		// wantNotified is the names of the objects that gained or lost a hostname because of another object, in order
+		wantNotified []string
+	}{
+		{
+			name:         "single claim wins",
+			steps:        []step{{older, []string{"a.example.com"}}},
+			wantLost:     map[string]string{},
+			wantNotified: []string{},
+		},
+		{
+			name:         "newer object loses to the object created first",
+			steps:        []step{{older, []string{"a.example.com"}}, {newer, []string{"a.example.com", "b.example.com"}}},
+			wantLost:     map[string]string{"a.example.com": "older"},
+			wantNotified: []string{},
+		},
+		{
+			name:         "higher priority takes over from the object created first",
+			steps:        []step{{older, []string{"a.example.com"}}, {preferred, []string{"a.example.com"}}},
+			wantLost:     map[string]string{},
+			wantNotified: []string{"older"},
+		},
+		{
+			name:         "giving up a hostname hands it over to the next claimant",
+			steps:        []step{{older, []string{"a.example.com"}}, {newer, []string{"a.example.com"}}, {older, []string{"b.example.com"}}},
+			wantLost:     map[string]string{},
+			wantNotified: []string{"newer"},
+		},
+		{
+			name:         "releasing an object hands its hostnames over to the next claimant",
+			steps:        []step{{older, []string{"a.example.com"}}, {newer, []string{"a.example.com"}}, {older, nil}},
+			wantLost:     map[string]string{},
+			wantNotified: []string{"newer"},
+		},
+		{
+			name:         "releasing a losing object notifies nobody",
+			steps:        []step{{older, []string{"a.example.com"}}, {newer, []string{"a.example.com"}}, {newer, nil}},
+			wantLost:     map[string]string{},
+			wantNotified: []string{},
+		},
+		{
+			name:         "lowering the priority hands the hostname over",
+			steps:        []step{{older, []string{"a.example.com"}}, {preferred, []string{"a.example.com"}}, {claim("preferred", 0, 300), []string{"a.example.com"}}},
+			wantLost:     map[string]string{"a.example.com": "older"},
+			wantNotified: []string{"older"},
+		},
+	}
+
+	for _, tt := range tests {
+		index := newHostnameIndex()
+		notified := []string{}
+		lost := map[string]hostnameClaim{}
+
+		for i, step := range tt.steps {
+			// only the changes caused by the last step are checked
+			if i == len(tt.steps)-1 {
+				index.onWinnerChange = func(item workItem) {
+					notified = append(notified, item.Name)
+				}
+			}
+			if step.hostnames == nil {
+				index.Release(step.claim.item)
+				lost = map[string]hostnameClaim{}
+				continue
+			}
+			lost = index.Claim(step.claim, step.hostnames)
+		}
+
+		gotLost := map[string]string{}
+		for hostname, winner := range lost {
+			gotLost[hostname] = winner.item.Name
+		}
+		if !reflect.DeepEqual(gotLost, tt.wantLost) {
+			t.Errorf("%v: lost = %v, want %v", tt.name, gotLost, tt.wantLost)
+		}
+		sort.Strings(notified)
+		if !reflect.DeepEqual(notified, tt.wantNotified) {
+			t.Errorf("%v: notified = %v, want %v", tt.name, notified, tt.wantNotified)
+		}
+	}
+}
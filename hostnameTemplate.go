@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const annotationGoogleCloudDNSHostnameTemplate string = "estafette.io/google-cloud-dns-hostname-template"
+
+var (
+	// defaultHostnameTemplate and defaultNodeHostnameTemplate hold the templates of the --hostname-template and --node-hostname-template
+	// flags, parsed once at startup; they're nil if the flags aren't set
+	defaultHostnameTemplate     *template.Template
+	defaultNodeHostnameTemplate *template.Template
+)
+
+// hostnameTemplateData is the data hostname templates are rendered with
+type hostnameTemplateData struct {
+	Name        string
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// hostnameTemplateError is returned when the hostname template of an object fails to parse or render, so it can be told apart from
+// failing api calls
+type hostnameTemplateError struct {
+	message string
+}
+
+func (e *hostnameTemplateError) Error() string {
+	return e.message
+}
+
+func newHostnameTemplateError(format string, a ...interface{}) error {
+	return &hostnameTemplateError{message: fmt.Sprintf(format, a...)}
+}
+
+// parseHostnameTemplate parses a hostname template, returning nil for an empty one
+func parseHostnameTemplate(hostnameTemplate string) (*template.Template, error) {
+	if hostnameTemplate == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New("hostname").Option("missingkey=zero").Parse(hostnameTemplate)
+	if err != nil {
+		return nil, newHostnameTemplateError("parsing hostname template %v failed: %v", hostnameTemplate, err)
+	}
+
+	return tmpl, nil
+}
+
+// renderHostnameTemplate renders the hostname template from the annotation, or if absent the default template, for an object; the
+// template can render a comma-separated list of hostnames; a template that fails to parse or render returns an error, so the object
+// isn't published without its templated hostnames
+func renderHostnameTemplate(metadata *metav1.ObjectMeta, defaultTemplate *template.Template) (hostnames []string, err error) {
+
+	tmpl := defaultTemplate
+	if hostnameTemplate, ok := metadata.Annotations[annotationGoogleCloudDNSHostnameTemplate]; ok {
+		tmpl, err = parseHostnameTemplate(hostnameTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tmpl == nil {
+		return
+	}
+
+	data := hostnameTemplateData{
+		Name:        metadata.GetName(),
+		Namespace:   metadata.GetNamespace(),
+		Labels:      metadata.Labels,
+		Annotations: metadata.Annotations,
+	}
+
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, data)
+	if err != nil {
+		return nil, newHostnameTemplateError("rendering hostname template %v failed: %v", tmpl.Root, err)
+	}
+
+	return strings.Split(rendered.String(), ","), nil
+}
+
+// addTemplatedHostnames merges the hostnames rendered from the hostname template with a comma-separated list of hostnames; the template
+// is only rendered for objects with dns enabled, so a broken template isn't reported on objects the controller doesn't manage
+func addTemplatedHostnames(hostnames string, metadata *metav1.ObjectMeta) (string, error) {
+	if metadata.Annotations[annotationGoogleCloudDNS] != "true" {
+		return hostnames, nil
+	}
+	templatedHostnames, err := renderHostnameTemplate(metadata, defaultHostnameTemplate)
+	if err != nil {
+		return hostnames, err
+	}
+	return joinHostnames(append(strings.Split(hostnames, ","), templatedHostnames...)), nil
+}
+
+// rejectHostnameTemplate reports the error of a hostname template that fails to parse or render with an event and in the state of the
+// object, whose records are left as they are until the template is fixed; the state is only stored when the error changes, so
+// storing it doesn't keep triggering watch events
+func rejectHostnameTemplate(client *k8s.Client, kind string, resource k8s.Resource, initiator string, currentState GoogleCloudDNSState, templateErr error) (status string, err error) {
+
+	metadata := resource.GetMetadata()
+
+	log.Error().Err(templateErr).Msgf("[%v] %v %v.%v - Invalid hostname template, skipping", initiator, kind, *metadata.Name, *metadata.Namespace)
+
+	if currentState.LastError == templateErr.Error() {
+		return "skipped", nil
+	}
+
+	recordEvent(client, kind, resource, eventTypeWarning, eventReasonInvalidHostname, "Skipped the hostname template: %v", templateErr)
+
+	currentState.SchemaVersion = stateSchemaVersion
+	currentState.LastError = templateErr.Error()
+
+	err = updateState(client, kind, resource, initiator, currentState)
+	if err != nil {
+		return "failed", err
+	}
+
+	return "skipped", nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func TestRenderHostnameTemplate(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		defaultTemplate string
+		want            []string
+		wantErr         bool
+		wantInvalid     bool
+	}{
+		{
+			name:            "no template",
+			defaultTemplate: "",
+			want:            nil,
+		},
+		{
+			name:            "default template with name and namespace",
+			defaultTemplate: "{{.Name}}.{{.Namespace}}.example.com",
+			want:            []string{"shop.default.example.com"},
+		},
+		{
+			name:            "annotation overrides the default template",
+			annotations:     map[string]string{annotationGoogleCloudDNSHostnameTemplate: "{{.Name}}.example.org"},
+			defaultTemplate: "{{.Name}}.{{.Namespace}}.example.com",
+			want:            []string{"shop.example.org"},
+		},
+		{
+			name:            "empty annotation disables the default template",
+			annotations:     map[string]string{annotationGoogleCloudDNSHostnameTemplate: ""},
+			defaultTemplate: "{{.Name}}.{{.Namespace}}.example.com",
+			want:            nil,
+		},
+		{
+			name:            "labels and a comma-separated list",
+			defaultTemplate: "{{.Name}}.{{.Labels.team}}.example.com,{{.Labels.team}}.example.com",
+			want:            []string{"shop.checkout.example.com", "checkout.example.com"},
+		},
+		{
+			name:            "missing label renders empty",
+			defaultTemplate: "{{.Name}}.{{.Labels.missing}}example.com",
+			want:            []string{"shop.example.com"},
+		},
+		{
+			name:            "unknown field",
+			defaultTemplate: "{{.Unknown}}.example.com",
+			wantErr:         true,
+		},
+		{
+			name:            "template that doesn't parse",
+			defaultTemplate: "{{.Name}.example.com",
+			wantErr:         true,
+		},
+		{
+			// the rendered hostnames are validated along with the other hostnames when the records are set
+			name:            "output that isn't a valid hostname is returned as is",
+			defaultTemplate: "{{.Name}}",
+			want:            []string{"shop"},
+			wantInvalid:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &metav1.ObjectMeta{
+				Name:        k8s.String("shop"),
+				Namespace:   k8s.String("default"),
+				Labels:      map[string]string{"team": "checkout"},
+				Annotations: tt.annotations,
+			}
+
+			defaultTemplate, err := parseHostnameTemplate(tt.defaultTemplate)
+			var got []string
+			if err == nil {
+				got, err = renderHostnameTemplate(metadata, defaultTemplate)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderHostnameTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := err.(*hostnameTemplateError); !ok {
+					t.Errorf("renderHostnameTemplate() error = %T, want *hostnameTemplateError", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("renderHostnameTemplate() = %q, want %q", got, tt.want)
+			}
+			for _, hostname := range got {
+				if validateHostname(hostname) == tt.wantInvalid {
+					t.Errorf("validateHostname(%q) = %v, want %v", hostname, !tt.wantInvalid, tt.wantInvalid)
+				}
+			}
+		})
+	}
+}
+
+func TestAddTemplatedHostnames(t *testing.T) {
+
+	brokenTemplate := "{{.Unknown}}.example.com"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{"dns enabled", map[string]string{annotationGoogleCloudDNS: "true", annotationGoogleCloudDNSHostnameTemplate: "{{.Name}}.example.com"}, "a.example.com,shop.example.com", false},
+		{"dns enabled with a broken template", map[string]string{annotationGoogleCloudDNS: "true", annotationGoogleCloudDNSHostnameTemplate: brokenTemplate}, "", true},
+		{"dns disabled skips the template", map[string]string{annotationGoogleCloudDNS: "false", annotationGoogleCloudDNSHostnameTemplate: "{{.Name}}.example.com"}, "a.example.com", false},
+		{"without the dns annotation a broken template isn't rendered", map[string]string{annotationGoogleCloudDNSHostnameTemplate: brokenTemplate}, "a.example.com", false},
+	}
+
+	for _, tt := range tests {
+		metadata := &metav1.ObjectMeta{Name: k8s.String("shop"), Namespace: k8s.String("default"), Annotations: tt.annotations}
+		got, err := addTemplatedHostnames("a.example.com", metadata)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: addTemplatedHostnames() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%v: addTemplatedHostnames() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
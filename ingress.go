@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// ingressAPIVersions are the api groups ingresses can be served from, in order of preference
+var ingressAPIVersions = []string{"networking.k8s.io/v1", "networking.k8s.io/v1beta1", "extensions/v1beta1"}
+
+// ingressAPIVersion is the api group ingresses are read from, as detected at startup
+var ingressAPIVersion = ingressAPIVersions[0]
+
+// Ingress represents an ingress as served by networking.k8s.io/v1; only the fields needed to derive dns records are decoded,
+// the fields in use are identical in networking.k8s.io/v1beta1 and extensions/v1beta1 so the same struct is used for those
+type Ingress struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Metadata   *metav1.ObjectMeta `json:"metadata"`
+	Spec       IngressSpec        `json:"spec"`
+	Status     IngressStatus      `json:"status"`
+}
+
+// GetMetadata returns the metadata of the ingress, required to implement k8s.Resource
+func (i *Ingress) GetMetadata() *metav1.ObjectMeta {
+	return i.Metadata
+}
+
+// IngressList represents a list of ingresses
+type IngressList struct {
+	Metadata *metav1.ListMeta `json:"metadata"`
+	Items    []*Ingress       `json:"items"`
+}
+
+// GetMetadata returns the metadata of the ingress list, required to implement k8s.ResourceList
+func (l *IngressList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+// IngressSpec holds the ingress spec fields the controller reads
+type IngressSpec struct {
+	IngressClassName *string       `json:"ingressClassName,omitempty"`
+	Rules            []IngressRule `json:"rules,omitempty"`
+	TLS              []IngressTLS  `json:"tls,omitempty"`
+}
+
+// IngressRule represents a host rule of an ingress
+type IngressRule struct {
+	Host string `json:"host,omitempty"`
+}
+
+// IngressTLS represents the tls configuration of an ingress
+type IngressTLS struct {
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// IngressStatus represents the status of an ingress
+type IngressStatus struct {
+	LoadBalancer LoadBalancerStatus `json:"loadBalancer"`
+}
+
+// LoadBalancerStatus represents the load balancer status of an ingress
+type LoadBalancerStatus struct {
+	Ingress []LoadBalancerIngress `json:"ingress,omitempty"`
+}
+
+// LoadBalancerIngress represents an ingress point of a load balancer
+type LoadBalancerIngress struct {
+	IP       string `json:"ip,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// the v1beta1 variants share the Ingress layout, but need their own types to be registered for a different api group
+type networkingV1beta1Ingress Ingress
+type networkingV1beta1IngressList IngressList
+type extensionsV1beta1Ingress Ingress
+type extensionsV1beta1IngressList IngressList
+
+func (i *networkingV1beta1Ingress) GetMetadata() *metav1.ObjectMeta   { return i.Metadata }
+func (l *networkingV1beta1IngressList) GetMetadata() *metav1.ListMeta { return l.Metadata }
+func (i *extensionsV1beta1Ingress) GetMetadata() *metav1.ObjectMeta   { return i.Metadata }
+func (l *extensionsV1beta1IngressList) GetMetadata() *metav1.ListMeta { return l.Metadata }
+
+func init() {
+	k8s.Register("networking.k8s.io", "v1", "ingresses", true, &Ingress{})
+	k8s.RegisterList("networking.k8s.io", "v1", "ingresses", true, &IngressList{})
+
+	k8s.Register("networking.k8s.io", "v1beta1", "ingresses", true, &networkingV1beta1Ingress{})
+	k8s.RegisterList("networking.k8s.io", "v1beta1", "ingresses", true, &networkingV1beta1IngressList{})
+
+	k8s.Register("extensions", "v1beta1", "ingresses", true, &extensionsV1beta1Ingress{})
+	k8s.RegisterList("extensions", "v1beta1", "ingresses", true, &extensionsV1beta1IngressList{})
+}
+
+// detectIngressAPIVersion returns the most preferred api group the api server serves ingresses from, probing by listing ingresses in namespace
+func detectIngressAPIVersion(client *k8s.Client, namespace string) (string, error) {
+	for _, apiVersion := range ingressAPIVersions {
+		err := client.List(context.Background(), namespace, newIngressResourceList(apiVersion), k8s.QueryParam("limit", "1"))
+		if err == nil {
+			return apiVersion, nil
+		}
+		if isAPIError(err, http.StatusNotFound) {
+			log.Debug().Msgf("Ingresses are not served from %v", apiVersion)
+			continue
+		}
+		return "", err
+	}
+
+	return "", fmt.Errorf("none of the ingress api versions %v is served by the api server", ingressAPIVersions)
+}
+
+// newIngressResource returns an empty ingress for the detected api group, to be used for get and watch calls
+func newIngressResource() k8s.Resource {
+	switch ingressAPIVersion {
+	case "networking.k8s.io/v1beta1":
+		return &networkingV1beta1Ingress{}
+	case "extensions/v1beta1":
+		return &extensionsV1beta1Ingress{}
+	}
+	return &Ingress{}
+}
+
+// newIngressResourceList returns an empty ingress list for the api group, to be used for list calls
+func newIngressResourceList(apiVersion string) k8s.ResourceList {
+	switch apiVersion {
+	case "networking.k8s.io/v1beta1":
+		return &networkingV1beta1IngressList{}
+	case "extensions/v1beta1":
+		return &extensionsV1beta1IngressList{}
+	}
+	return &IngressList{}
+}
+
+// toIngress converts an ingress of any of the supported api groups to an Ingress
+func toIngress(resource k8s.Resource) *Ingress {
+	switch r := resource.(type) {
+	case *networkingV1beta1Ingress:
+		return (*Ingress)(r)
+	case *extensionsV1beta1Ingress:
+		return (*Ingress)(r)
+	case *Ingress:
+		return r
+	}
+	return nil
+}
+
+// toIngressList converts an ingress list of any of the supported api groups to an IngressList
+func toIngressList(resourceList k8s.ResourceList) *IngressList {
+	switch l := resourceList.(type) {
+	case *networkingV1beta1IngressList:
+		return (*IngressList)(l)
+	case *extensionsV1beta1IngressList:
+		return (*IngressList)(l)
+	case *IngressList:
+		return l
+	}
+	return nil
+}
+
+// ingressResource converts an Ingress back to the type registered for the detected api group, to be used for update calls
+func ingressResource(ingress *Ingress) k8s.Resource {
+	switch ingressAPIVersion {
+	case "networking.k8s.io/v1beta1":
+		return (*networkingV1beta1Ingress)(ingress)
+	case "extensions/v1beta1":
+		return (*extensionsV1beta1Ingress)(ingress)
+	}
+	return ingress
+}
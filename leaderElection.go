@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+const coordinationAPIGroup string = "coordination.k8s.io"
+
+// leaseTimeFormat is the microsecond precision format of the acquire and renew times of a lease
+const leaseTimeFormat string = "2006-01-02T15:04:05.000000Z07:00"
+
+// serviceAccountNamespaceFile holds the namespace the pod runs in
+const serviceAccountNamespaceFile string = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Lease represents a coordination.k8s.io/v1 lease, used to elect the leader among the replicas of the controller
+type Lease struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Metadata   *metav1.ObjectMeta `json:"metadata"`
+	Spec       LeaseSpec          `json:"spec"`
+}
+
+// GetMetadata returns the metadata of the lease, required to implement k8s.Resource
+func (l *Lease) GetMetadata() *metav1.ObjectMeta {
+	return l.Metadata
+}
+
+// LeaseList represents a list of leases
+type LeaseList struct {
+	Metadata *metav1.ListMeta `json:"metadata"`
+	Items    []*Lease         `json:"items"`
+}
+
+// GetMetadata returns the metadata of the lease list, required to implement k8s.ResourceList
+func (l *LeaseList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+// LeaseSpec represents the spec of a lease
+type LeaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+func init() {
+	k8s.Register(coordinationAPIGroup, "v1", "leases", true, &Lease{})
+	k8s.RegisterList(coordinationAPIGroup, "v1", "leases", true, &LeaseList{})
+}
+
+// leaderElector makes sure only one replica of the controller reconciles objects, by holding a lease that the other replicas
+// wait to take over once it's no longer renewed
+type leaderElector struct {
+	client        *k8s.Client
+	namespace     string
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	mutex *sync.RWMutex
+	// observed is true once the lease has been read or written successfully, so it's known whether this replica leads
+	observed bool
+	leading  bool
+	holder   string
+	// observedRecord and observedTime track when the lease last changed according to the local clock, so expiry doesn't depend
+	// on the clocks of the replicas being in sync
+	observedRecord string
+	observedTime   time.Time
+}
+
+// newLeaderElector returns a leader elector for the lease with the name in the namespace; if the namespace is empty the namespace
+// the pod runs in is used
+func newLeaderElector(client *k8s.Client, namespace, name, identity string, leaseDuration, renewDeadline, retryPeriod time.Duration) (*leaderElector, error) {
+
+	if namespace == "" {
+		data, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the namespace of the pod failed, set --leader-election-namespace instead: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = hostname
+	}
+	if renewDeadline >= leaseDuration {
+		return nil, fmt.Errorf("the renew deadline of %v should be shorter than the lease duration of %v", renewDeadline, leaseDuration)
+	}
+
+	return &leaderElector{
+		client:        client,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		mutex:         &sync.RWMutex{},
+	}, nil
+}
+
+// Run blocks until the lease is acquired, then calls onStartedLeading and keeps renewing the lease; if it can't be renewed within
+// the renew deadline another replica may take over, so the process exits to stop reconciling right away
+func (le *leaderElector) Run(onStartedLeading func()) {
+
+	log.Info().Msgf("Acquiring lease %v.%v as %v...", le.name, le.namespace, le.identity)
+	for {
+		acquired, err := le.tryAcquireOrRenew()
+		if err != nil {
+			log.Warn().Err(err).Msgf("Acquiring lease %v.%v failed", le.name, le.namespace)
+		}
+		if acquired {
+			break
+		}
+		time.Sleep(le.retryPeriod)
+	}
+
+	log.Info().Msgf("Acquired lease %v.%v, started leading", le.name, le.namespace)
+	le.setLeading(true)
+	go onStartedLeading()
+
+	lastRenewed := time.Now()
+	for {
+		time.Sleep(le.retryPeriod)
+
+		renewed, err := le.tryAcquireOrRenew()
+		if renewed {
+			lastRenewed = time.Now()
+			continue
+		}
+		if err == nil {
+			le.setLeading(false)
+			log.Fatal().Msgf("Lease %v.%v has been taken over by %v, stopped leading", le.name, le.namespace, le.getHolder())
+		}
+		log.Warn().Err(err).Msgf("Renewing lease %v.%v failed", le.name, le.namespace)
+		if time.Since(lastRenewed) > le.renewDeadline {
+			le.setLeading(false)
+			log.Fatal().Msgf("Renewing lease %v.%v failed for longer than %v, stopped leading", le.name, le.namespace, le.renewDeadline)
+		}
+	}
+}
+
+// tryAcquireOrRenew creates the lease, takes it over once it has expired or renews it if this replica already holds it; it
+// returns true if this replica holds the lease afterwards
+func (le *leaderElector) tryAcquireOrRenew() (bool, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), le.renewDeadline)
+	defer cancel()
+
+	now := time.Now()
+	nowString := now.UTC().Format(leaseTimeFormat)
+	leaseDurationSeconds := int32(le.leaseDuration / time.Second)
+
+	var lease Lease
+	err := le.client.Get(ctx, le.namespace, le.name, &lease)
+	if isAPIError(err, http.StatusNotFound) {
+		var leaseTransitions int32
+		lease = Lease{
+			Metadata: &metav1.ObjectMeta{
+				Name:      k8s.String(le.name),
+				Namespace: k8s.String(le.namespace),
+			},
+			Spec: LeaseSpec{
+				HolderIdentity:       k8s.String(le.identity),
+				LeaseDurationSeconds: &leaseDurationSeconds,
+				AcquireTime:          &nowString,
+				RenewTime:            &nowString,
+				LeaseTransitions:     &leaseTransitions,
+			},
+		}
+		err = le.client.Create(ctx, &lease)
+		if err != nil {
+			return false, err
+		}
+		le.observe(lease, now)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	le.observe(lease, now)
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if holder != le.identity && holder != "" && !le.hasExpired(lease, now) {
+		return false, nil
+	}
+
+	if holder != le.identity {
+		var leaseTransitions int32
+		if lease.Spec.LeaseTransitions != nil {
+			leaseTransitions = *lease.Spec.LeaseTransitions
+		}
+		if holder != "" {
+			leaseTransitions++
+		}
+		lease.Spec.HolderIdentity = k8s.String(le.identity)
+		lease.Spec.AcquireTime = &nowString
+		lease.Spec.LeaseTransitions = &leaseTransitions
+	}
+	lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+	lease.Spec.RenewTime = &nowString
+
+	// the update fails with a conflict if another replica changed the lease since it was read
+	err = le.client.Update(ctx, &lease)
+	if err != nil {
+		return false, err
+	}
+	le.observe(lease, now)
+
+	return true, nil
+}
+
+// observe records the lease as seen at the time, resetting the expiry timer whenever the holder or renew time changed
+func (le *leaderElector) observe(lease Lease, now time.Time) {
+	le.mutex.Lock()
+	defer le.mutex.Unlock()
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	renewTime := ""
+	if lease.Spec.RenewTime != nil {
+		renewTime = *lease.Spec.RenewTime
+	}
+
+	record := fmt.Sprintf("%v/%v", holder, renewTime)
+	if record != le.observedRecord {
+		le.observedRecord = record
+		le.observedTime = now
+	}
+	le.observed = true
+	le.holder = holder
+}
+
+// hasExpired returns true if the lease hasn't changed for longer than its duration
+func (le *leaderElector) hasExpired(lease Lease, now time.Time) bool {
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+
+	leaseDuration := le.leaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		leaseDuration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return now.After(le.observedTime.Add(leaseDuration))
+}
+
+// Release gives up the lease if this replica holds it, so a standby replica can take over without waiting for it to expire
+func (le *leaderElector) Release() {
+	if le == nil || !le.isLeading() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), le.renewDeadline)
+	defer cancel()
+
+	var lease Lease
+	err := le.client.Get(ctx, le.namespace, le.name, &lease)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Releasing lease %v.%v failed", le.name, le.namespace)
+		return
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != le.identity {
+		return
+	}
+
+	nowString := time.Now().UTC().Format(leaseTimeFormat)
+	leaseDurationSeconds := int32(1)
+	lease.Spec.HolderIdentity = k8s.String("")
+	lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+	lease.Spec.RenewTime = &nowString
+
+	err = le.client.Update(ctx, &lease)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Releasing lease %v.%v failed", le.name, le.namespace)
+		return
+	}
+
+	le.setLeading(false)
+	log.Info().Msgf("Released lease %v.%v", le.name, le.namespace)
+}
+
+func (le *leaderElector) setLeading(leading bool) {
+	le.mutex.Lock()
+	le.leading = leading
+	le.mutex.Unlock()
+
+	if leading {
+		isLeader.Set(1)
+	} else {
+		isLeader.Set(0)
+	}
+}
+
+// isLeading returns true if this replica holds the lease; a nil leader elector means leader election is disabled and this replica
+// always leads
+func (le *leaderElector) isLeading() bool {
+	if le == nil {
+		return true
+	}
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+
+	return le.leading
+}
+
+// isReady returns true once it's known whether this replica leads or stands by
+func (le *leaderElector) isReady() bool {
+	if le == nil {
+		return true
+	}
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+
+	return le.observed
+}
+
+func (le *leaderElector) getHolder() string {
+	le.mutex.RLock()
+	defer le.mutex.RUnlock()
+
+	return le.holder
+}
+
+// leadershipDescription returns whether this replica leads as shown on the liveness and readiness endpoints
+func (le *leaderElector) leadershipDescription() string {
+	if le.isLeading() {
+		return "leader"
+	}
+	return "standby"
+}
+
+// initHealthEndpoints serves the /liveness and /readiness endpoints on port 5000, both reporting whether this replica is the
+// leader; a standby replica is ready as soon as it has seen the lease, so rolling updates aren't blocked by it
+func initHealthEndpoints(elector *leaderElector) {
+	go func() {
+		log.Debug().Str("port", ":5000").Msg("Serving /liveness and /readiness endpoints...")
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/liveness", func(w http.ResponseWriter, _ *http.Request) {
+			io.WriteString(w, fmt.Sprintf("I'm alive! (%v)\n", elector.leadershipDescription()))
+		})
+		mux.HandleFunc("/readiness", func(w http.ResponseWriter, _ *http.Request) {
+			if !elector.isReady() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				io.WriteString(w, "Waiting for the leader election lease...\n")
+				return
+			}
+			io.WriteString(w, fmt.Sprintf("I'm ready! (%v)\n", elector.leadershipDescription()))
+		})
+
+		if err := http.ListenAndServe(":5000", mux); err != nil {
+			log.Fatal().Err(err).Msg("Starting /liveness and /readiness listener failed")
+		}
+	}()
+}
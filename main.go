@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -16,26 +18,108 @@ import (
 
 	"github.com/ericchiang/k8s"
 	corev1 "github.com/ericchiang/k8s/apis/core/v1"
-	v1beta1 "github.com/ericchiang/k8s/apis/extensions/v1beta1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 const annotationGoogleCloudDNS string = "estafette.io/google-cloud-dns"
 const annotationGoogleCloudDNSHostnames string = "estafette.io/google-cloud-dns-hostnames"
+const annotationGoogleCloudDNSHostnamesExclude string = "estafette.io/google-cloud-dns-hostnames-exclude"
+
+// annotationValueAuto as value of the hostnames annotation on an ingress derives the hostnames from its rules and tls hosts
+const annotationValueAuto string = "auto"
 
 const annotationGoogleCloudDNSState string = "estafette.io/google-cloud-dns-state"
 
-// GoogleCloudDNSState represents the state of the service at Google Cloud DNS
+// stateSchemaVersion is the version of the state stored in the annotation; annotations without a version are migrated when read
+const stateSchemaVersion int = 2
+
+// statuses of the individual dns records in the state
+const (
+	recordStatusCreated  string = "created"
+	recordStatusUpdated  string = "updated"
+	recordStatusInvalid  string = "invalid"
+	recordStatusConflict string = "conflict"
+	recordStatusDenied   string = "denied"
+	recordStatusFailed   string = "failed"
+	// recordStatusSynced is the status of records migrated from an older state, which only stored that they were synced
+	recordStatusSynced string = "synced"
+	// recordStatusOwned is the status of migrated records once the owner record they lacked has been written
+	recordStatusOwned string = "owned"
+)
+
+// GoogleCloudDNSState represents the state of the service at Google Cloud DNS; enabled, hostnames and ipAddress reflect the last
+// successful sync, so a failed sync is retried
 type GoogleCloudDNSState struct {
-	Enabled   string `json:"enabled"`
-	Hostnames string `json:"hostnames"`
-	IPAddress string `json:"ipAddress"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Enabled       string `json:"enabled"`
+	Hostnames     string `json:"hostnames"`
+	IPAddress     string `json:"ipAddress"`
+	// RecordType is the type of the records, A if empty; Target holds the dns name of CNAME records instead of the ip address
+	RecordType string `json:"recordType,omitempty"`
+	Target     string `json:"target,omitempty"`
+	// NotReadySince is when a service that requires ready endpoints was first seen without any, to time its hold-down
+	NotReadySince string                      `json:"notReadySince,omitempty"`
+	Records       []GoogleCloudDNSRecordState `json:"records,omitempty"`
+	LastSyncTime  string                      `json:"lastSyncTime,omitempty"`
+	LastError     string                      `json:"lastError,omitempty"`
+	// ChangeID is the id of the last change made to Cloud DNS
+	ChangeID string `json:"changeId,omitempty"`
+}
+
+// GoogleCloudDNSRecordState represents the result of the last sync of the dns record of a single hostname
+type GoogleCloudDNSRecordState struct {
+	Hostname string `json:"hostname"`
+	Type     string `json:"type"`
+	TTL      int64  `json:"ttl"`
+	Zone     string `json:"zone"`
+	Status   string `json:"status"`
+	ChangeID string `json:"changeId,omitempty"`
+	// Targets holds the values of records that don't point to the ip address of the object, like the records of a dns endpoint
+	Targets []string `json:"targets,omitempty"`
 }
 
 var (
-	googleCloudDNSProject = kingpin.Flag("project", "The Google Cloud project id the Cloud DNS zone is configured in.").Envar("GOOGLE_CLOUD_DNS_PROJECT").Required().String()
-	googleCloudDNSZone    = kingpin.Flag("zone", "The Google Cloud zone name to use Cloud DNS for.").Envar("GOOGLE_CLOUD_DNS_ZONE").Required().String()
+	googleCloudDNSProject     = kingpin.Flag("project", "The Google Cloud project id the Cloud DNS zone is configured in.").Envar("GOOGLE_CLOUD_DNS_PROJECT").Required().String()
+	googleCloudDNSZone        = kingpin.Flag("zone", "The Google Cloud zone name to use Cloud DNS for.").Envar("GOOGLE_CLOUD_DNS_ZONE").Required().String()
+	ownerID                   = kingpin.Flag("owner-id", "The identity of this controller instance, stored in the txt records marking which object owns a dns record; set it to a unique value per cluster when clusters share a zone.").Default(defaultOwnerID).Envar("OWNER_ID").String()
+	ingressHostnamesFromRules = kingpin.Flag("ingress-hostnames-from-rules", "Add the hosts in the rules and tls sections of annotated ingresses to their hostnames, as if the hostnames annotation is set to auto.").Envar("INGRESS_HOSTNAMES_FROM_RULES").Bool()
+	hostnameTemplateFlag      = kingpin.Flag("hostname-template", "Go template to generate hostnames for annotated objects from their .Name, .Namespace, .Labels and .Annotations, for example {{.Name}}-{{.Namespace}}.preview.example.com.").Envar("HOSTNAME_TEMPLATE").String()
+	namespaces                = kingpin.Flag("namespace", "Only process objects in this namespace; can be repeated. Defaults to all namespaces.").Strings()
+	excludeNamespaces         = kingpin.Flag("exclude-namespace", "Never process objects in this namespace; can be repeated.").Strings()
+	labelSelector             = kingpin.Flag("label-selector", "Only process objects matching this label selector; for gateways it applies to the gateway, not to the http routes attached to it.").Envar("LABEL_SELECTOR").String()
+	ingressClasses            = kingpin.Flag("ingress-class", "Only process ingresses of this class, from spec.ingressClassName or the kubernetes.io/ingress.class annotation; can be repeated.").Strings()
+	loadBalancerClasses       = kingpin.Flag("load-balancer-class", "Only process services with this spec.loadBalancerClass; can be repeated.").Strings()
+	workers                   = kingpin.Flag("workers", "The number of objects that are reconciled in parallel.").Default("4").Envar("WORKERS").Int()
+	dnsRequestsPerSecond      = kingpin.Flag("dns-requests-per-second", "The maximum average rate of requests to the Cloud DNS api, shared by all workers; 0 disables the limit.").Default("5").Envar("DNS_REQUESTS_PER_SECOND").Float64()
+	dnsRequestsBurst          = kingpin.Flag("dns-requests-burst", "The number of requests to the Cloud DNS api allowed in a burst above the average rate.").Default("10").Envar("DNS_REQUESTS_BURST").Int()
+	leaderElect               = kingpin.Flag("leader-elect", "Elect a leader among the replicas with a coordination.k8s.io lease, so only one replica reconciles objects at a time.").Default("true").Envar("LEADER_ELECT").Bool()
+	leaderElectionNamespace   = kingpin.Flag("leader-election-namespace", "The namespace of the leader election lease. Defaults to the namespace the pod runs in.").Envar("POD_NAMESPACE").String()
+	leaderElectionLeaseName   = kingpin.Flag("leader-election-lease-name", "The name of the leader election lease.").Default("estafette-google-cloud-dns").Envar("LEADER_ELECTION_LEASE_NAME").String()
+	leaderElectionIdentity    = kingpin.Flag("leader-election-identity", "The identity of this replica in the leader election lease. Defaults to the hostname.").Envar("POD_NAME").String()
+	leaseDuration             = kingpin.Flag("lease-duration", "The duration after which standby replicas take over a lease that isn't renewed.").Default("15s").Duration()
+	renewDeadline             = kingpin.Flag("renew-deadline", "The duration the leader keeps retrying to renew the lease before it stops leading.").Default("10s").Duration()
+	retryPeriod               = kingpin.Flag("retry-period", "The interval at which the lease is acquired or renewed.").Default("2s").Duration()
+	domainPolicyFile          = kingpin.Flag("domain-policy-file", "Json file with the policy that authorizes namespaces to claim hostnames; reloaded when it changes.").Envar("DOMAIN_POLICY_FILE").String()
+	domainPolicyConfigMap     = kingpin.Flag("domain-policy-configmap", "Config map in the form namespace/name with the policy that authorizes namespaces to claim hostnames under the policy.json key; polled for changes.").Envar("DOMAIN_POLICY_CONFIGMAP").String()
+	gcInterval                = kingpin.Flag("gc-interval", "The interval at which dns records owned by this controller that no object claims anymore are garbage collected; 0 disables garbage collection.").Default("1h").Envar("GC_INTERVAL").Duration()
+	gcGracePeriod             = kingpin.Flag("gc-grace-period", "The duration a dns record has to be unclaimed before it's garbage collected.").Default("1h").Envar("GC_GRACE_PERIOD").Duration()
+	stateStore                = kingpin.Flag("state-store", "Where the state of the dns records of an object is stored, in an annotation on the object or in a DNSRecordState custom resource next to it.").Default(stateStoreAnnotation).Envar("STATE_STORE").Enum(stateStoreAnnotation, stateStoreCustomResource)
+	gcDryRun                  = kingpin.Flag("gc-dry-run", "Only report the orphaned dns records garbage collection would delete; garbage collection doesn't delete anything while --owner-id isn't set either.").Envar("GC_DRY_RUN").Bool()
+	webhookPort               = kingpin.Flag("webhook-port", "The port to serve the validating admission webhook on over https; 0 disables the webhook.").Default("0").Envar("WEBHOOK_PORT").Int()
+	webhookCertFile           = kingpin.Flag("webhook-cert-file", "The tls certificate the validating admission webhook is served with.").Default("/webhook-certs/tls.crt").Envar("WEBHOOK_CERT_FILE").String()
+	webhookKeyFile            = kingpin.Flag("webhook-key-file", "The tls key the validating admission webhook is served with.").Default("/webhook-certs/tls.key").Envar("WEBHOOK_KEY_FILE").String()
+	webhookFailOpen           = kingpin.Flag("webhook-fail-open", "Admit objects when the validating admission webhook can't check their hostnames because an api call fails; disable to reject them instead.").Default("true").Envar("WEBHOOK_FAIL_OPEN").Bool()
+	enableGatewayAPI          = kingpin.Flag("enable-gateway-api", "Set dns records for annotated Gateway API gateways as well; requires the gateway.networking.k8s.io crds to be installed.").Envar("ENABLE_GATEWAY_API").Bool()
+	enableHeadlessServices    = kingpin.Flag("enable-headless-services", "Set a record per ready endpoint for annotated headless services, next to a record for the service pointing to all of them.").Envar("ENABLE_HEADLESS_SERVICES").Bool()
+	enableReadyEndpoints      = kingpin.Flag("enable-ready-endpoints", "Allow services to only publish their records while they have ready endpoints, with the require-ready-endpoints annotation.").Envar("ENABLE_READY_ENDPOINTS").Bool()
+	readyHoldDown             = kingpin.Flag("ready-hold-down", "The duration the records of a service that requires ready endpoints are kept after its last endpoint became unready, before they're switched to the fallback target or withdrawn.").Default("1m").Envar("READY_HOLD_DOWN").Duration()
+	enableNodes               = kingpin.Flag("enable-nodes", "Set A and AAAA records for the external ip addresses of annotated nodes, or the nodes matching --node-selector.").Envar("ENABLE_NODES").Bool()
+	enableNodeExternalIPs     = kingpin.Flag("enable-node-external-ips", "Allow services to use the nodeExternalIPs address source, which lists and watches the nodes; the helm chart disables it when it's limited to namespaces, since that requires a cluster role.").Default("true").Envar("ENABLE_NODE_EXTERNAL_IPS").Bool()
+	nodeSelector              = kingpin.Flag("node-selector", "Set records for the nodes matching this label selector, as if they have the dns annotation.").Envar("NODE_SELECTOR").String()
+	nodeHostnameTemplate      = kingpin.Flag("node-hostname-template", "A Go template rendering the hostnames of nodes from their .Name, .Labels and .Annotations, unless they have the hostname template annotation.").Envar("NODE_HOSTNAME_TEMPLATE").String()
+	enableDNSEndpoints        = kingpin.Flag("enable-dns-endpoints", "Set the dns records listed in DNSEndpoint resources as well; requires the dnsendpoints.dns.estafette.io crd to be installed.").Envar("ENABLE_DNS_ENDPOINTS").Bool()
 
 	appgroup  string
 	app       string
@@ -52,11 +136,55 @@ var (
 		},
 		[]string{"namespace", "status", "initiator", "type"},
 	)
+
+	processingDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "estafette_google_cloud_dns_processing_duration_seconds",
+			Help: "Duration of processing a queued object.",
+		},
+		[]string{"status", "type"},
+	)
+
+	orphanedRecordsTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_google_cloud_dns_orphaned_record_totals",
+			Help: "Number of orphaned Google Cloud DNS records handled by garbage collection.",
+		},
+		[]string{"status"},
+	)
+
+	hostnameConflictTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_google_cloud_dns_hostname_conflict_totals",
+			Help: "Number of hostnames skipped because another object claiming them takes precedence.",
+		},
+		[]string{"namespace", "type"},
+	)
+
+	policyViolationTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_google_cloud_dns_policy_violation_totals",
+			Help: "Number of hostnames rejected because the domain policy doesn't allow their namespace to claim them.",
+		},
+		[]string{"namespace", "type"},
+	)
+
+	isLeader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "estafette_google_cloud_dns_leader",
+			Help: "Whether this replica is the leader that reconciles objects, 1 if it is and 0 if it's standing by.",
+		},
+	)
 )
 
 func init() {
 	// Metrics have to be registered to be exposed:
 	prometheus.MustRegister(dnsRecordsTotals)
+	prometheus.MustRegister(processingDuration)
+	prometheus.MustRegister(orphanedRecordsTotals)
+	prometheus.MustRegister(hostnameConflictTotals)
+	prometheus.MustRegister(policyViolationTotals)
+	prometheus.MustRegister(isLeader)
 }
 
 func main() {
@@ -67,8 +195,16 @@ func main() {
 	// init log format from envvar ESTAFETTE_LOG_FORMAT
 	foundation.InitLoggingFromEnv(foundation.NewApplicationInfo(appgroup, app, version, branch, revision, buildDate))
 
-	// init /liveness endpoint
-	foundation.InitLiveness()
+	// parse the hostname templates of the flags once, so a broken one stops the controller instead of failing for each object
+	var err error
+	defaultHostnameTemplate, err = parseHostnameTemplate(*hostnameTemplateFlag)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Parsing --hostname-template failed")
+	}
+	defaultNodeHostnameTemplate, err = parseHostnameTemplate(*nodeHostnameTemplate)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Parsing --node-hostname-template failed")
+	}
 
 	// create kubernetes api client
 	kubeClient, err := k8s.NewInClusterClient()
@@ -76,165 +212,289 @@ func main() {
 		log.Fatal().Err(err).Msg("Creating Kubernetes api client failed")
 	}
 
+	// only the replica holding the lease reconciles objects, the others stand by to take over
+	var elector *leaderElector
+	if *leaderElect {
+		elector, err = newLeaderElector(kubeClient, *leaderElectionNamespace, *leaderElectionLeaseName, *leaderElectionIdentity, *leaseDuration, *renewDeadline, *retryPeriod)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Creating leader elector failed")
+		}
+	}
+
+	// init /liveness and /readiness endpoints
+	initHealthEndpoints(elector)
+
+	// detect which api group serves ingresses, preferring networking.k8s.io/v1
+	ingressAPIVersion, err = detectIngressAPIVersion(kubeClient, watchNamespaces()[0])
+	if err != nil {
+		log.Fatal().Err(err).Msg("Detecting ingress api version failed")
+	}
+	log.Info().Msgf("Reading ingresses from %v", ingressAPIVersion)
+
+	// load the policy that authorizes namespaces to claim hostnames, if any
+	if *domainPolicyFile != "" {
+		err = loadDomainPolicyFile(*domainPolicyFile)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Loading domain policy from file %v failed", *domainPolicyFile)
+		}
+	} else if *domainPolicyConfigMap != "" {
+		err = loadDomainPolicyConfigMap(kubeClient, *domainPolicyConfigMap)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Loading domain policy from config map %v failed", *domainPolicyConfigMap)
+		}
+	}
+
 	foundation.InitMetrics()
 
 	gracefulShutdown, waitGroup := foundation.InitGracefulShutdownHandling()
 
-	// create service to Google Cloud DNS
-	dnsService := NewGoogleCloudDNSService(*googleCloudDNSProject, *googleCloudDNSZone)
+	// create service to Google Cloud DNS, with a rate limit shared by all workers
+	dnsRateLimiter := newRateLimiter(*dnsRequestsPerSecond, *dnsRequestsBurst)
+	dnsService := NewGoogleCloudDNSService(*googleCloudDNSProject, *googleCloudDNSZone, dnsRateLimiter)
 
 	foundation.WatchForFileChanges(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), func(event fsnotify.Event) {
 		log.Info().Msg("Key file changed, reinitializing dns service...")
-		dnsService = NewGoogleCloudDNSService(*googleCloudDNSProject, *googleCloudDNSZone)
+		dnsService = NewGoogleCloudDNSService(*googleCloudDNSProject, *googleCloudDNSZone, dnsRateLimiter)
 	})
 
-	// watch services for all namespaces
-	go func(waitGroup *sync.WaitGroup) {
-		// loop indefinitely
-		for {
-			log.Info().Msg("Watching services for all namespaces...")
+	// validate the dns annotations of objects when they're applied; served by all replicas, not only the leader
+	if *webhookPort > 0 {
+		startAdmissionWebhook(kubeClient, func() *GoogleCloudDNSService { return dnsService })
+	}
 
-			var service corev1.Service
-			watcher, err := kubeClient.Watch(context.Background(), k8s.AllNamespaces, &service, k8s.Timeout(time.Duration(300)*time.Second))
-			defer watcher.Close()
+	// all objects to reconcile go through a single queue, so the same object is never processed concurrently
+	queue := newWorkQueue()
 
-			if err != nil {
-				log.Error().Err(err).Msg("WatchServices call failed")
-			} else {
-				// loop indefinitely, unless it errors
-				for {
-					service := new(corev1.Service)
-					event, err := watcher.Next(service)
-					if err != nil {
-						log.Error().Err(err).Msg("Getting next event from service watcher failed")
-						break
-					}
+	// an object gets processed again once it gains or loses a hostname claimed by another object as well
+	hostnameClaims.onWinnerChange = func(item workItem) {
+		item.Initiator = "conflict"
+		queue.Add(item)
+	}
 
-					if event == k8s.EventAdded || event == k8s.EventModified {
-						waitGroup.Add(1)
-						status, err := processService(dnsService, kubeClient, service, fmt.Sprintf("watcher:%v", event))
-						dnsRecordsTotals.With(prometheus.Labels{"namespace": *service.Metadata.Namespace, "status": status, "initiator": "watcher", "type": "service"}).Inc()
-						waitGroup.Done()
+	// a service without ready endpoints gets processed again once its hold-down has passed
+	scheduleRequeue = queue.AddAfter
 
-						if err != nil {
-							log.Error().Err(err).Msgf("Processing service %v.%v failed", *service.Metadata.Name, *service.Metadata.Namespace)
-							continue
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "estafette_google_cloud_dns_queue_depth",
+			Help: "Number of objects waiting in the queue to be processed.",
+		},
+		func() float64 { return float64(queue.Len()) },
+	))
+
+	// queueNodeExternalIPsServices queues the services with the nodeExternalIPs address source when the addresses of a node change, since
+	// their records point to the addresses of the ready nodes
+	queueNodeExternalIPsServices := func(event string, resource k8s.Resource) {
+		if !nodeAddressesChanged(event, resource.(*corev1.Node)) {
+			return
+		}
+		items, err := getNodeExternalIPsServiceWorkItems(kubeClient, fmt.Sprintf("watcher:%v", event))
+		if err != nil {
+			log.Error().Err(err).Msgf("Listing the services using the %v address source after node %v changed failed", addressSourceNodeExternalIPs, resource.GetMetadata().GetName())
+			return
+		}
+		for _, item := range items {
+			queue.Add(item)
+		}
+	}
+
+	startReconciling := func() {
+		// watch services, ingresses, gateways and the other kinds dns records are set for
+		for _, scope := range reconciledScopes() {
+			go func(scope watchedScope) {
+				listAndWatch(kubeClient, scope.namespace, scope.kind, func(event string, resource k8s.Resource) {
+					if event == k8s.EventAdded || event == k8s.EventModified {
+						queue.Add(newWorkItem(scope.kind.kind, resource, fmt.Sprintf("watcher:%v", event)))
+					}
+					if event == k8s.EventDeleted {
+						hostnameClaims.Release(newWorkItem(scope.kind.kind, resource, ""))
+
+						// an object still carrying the finalizer is reported as deleted when it leaves the label selector, after which
+						// its deletion isn't watched anymore
+						if isBeingReleased(resource.GetMetadata()) {
+							queue.Add(newWorkItem(scope.kind.kind, resource, fmt.Sprintf("watcher:%v", event)))
+						} else if hasLeftLabelSelector(event, resource.GetMetadata()) {
+							if err := letGo(kubeClient, scope.kind.kind, resource, fmt.Sprintf("watcher:%v", event)); err != nil {
+								log.Error().Err(err).Msgf("Removing the finalizer from %v %v.%v failed", scope.kind.kind, resource.GetMetadata().GetName(), resource.GetMetadata().GetNamespace())
+							}
 						}
 					}
-				}
+					if scope.kind.kind == nodeKind.kind && *enableNodeExternalIPs {
+						queueNodeExternalIPsServices(event, resource)
+					}
+				})
+			}(scope)
+		}
+
+		for _, namespace := range watchNamespaces() {
+			if *enableGatewayAPI {
+				// watch http routes for the namespace, since their hostnames get published for the gateways they're attached to
+				go listAndWatch(kubeClient, namespace, httpRouteKind, func(event string, resource k8s.Resource) {
+					for _, item := range getHTTPRouteGatewayWorkItems(resource.(*HTTPRoute), fmt.Sprintf("watcher:%v", event)) {
+						queue.Add(item)
+					}
+				})
 			}
 
-			// sleep random time between 22 and 37 seconds
-			sleepTime := foundation.ApplyJitter(30)
-			log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+			if *enableHeadlessServices || *enableReadyEndpoints {
+				// watch endpoint slices for the namespace, since the records of headless services and of services that require ready
+				// endpoints follow their endpoints
+				go listAndWatch(kubeClient, namespace, endpointSliceKind, func(event string, resource k8s.Resource) {
+					if item, ok := getEndpointSliceServiceWorkItem(resource.(*EndpointSlice), fmt.Sprintf("watcher:%v", event)); ok {
+						queue.Add(item)
+					}
+				})
+			}
 		}
-	}(waitGroup)
 
-	// watch ingresses for all namespaces
-	go func(waitGroup *sync.WaitGroup) {
-		// loop indefinitely
-		for {
-			log.Info().Msg("Watching ingresses for all namespaces...")
-
-			var ingress v1beta1.Ingress
-			watcher, err := kubeClient.Watch(context.Background(), k8s.AllNamespaces, &ingress, k8s.Timeout(time.Duration(300)*time.Second))
-			defer watcher.Close()
+		// watch nodes for the services with the nodeExternalIPs address source, unless they're watched to set their own records already
+		if *enableNodeExternalIPs && !*enableNodes {
+			go listAndWatch(kubeClient, k8s.AllNamespaces, nodeKind, queueNodeExternalIPsServices)
+		}
 
-			if err != nil {
-				log.Error().Err(err).Msg("WatchIngresses call failed")
-			} else {
-				// loop indefinitely, unless it errors
-				for {
-					ingress := new(v1beta1.Ingress)
-					event, err := watcher.Next(ingress)
+		// queue all objects periodically, as a safety net for missed watch events
+		go func() {
+			// loop indefinitely
+			for {
+				for _, scope := range reconciledScopes() {
+					log.Info().Msgf("Listing %v for %v...", scope.kind.name, namespaceDescription(scope.namespace))
+					list := scope.kind.newList()
+					err := kubeClient.List(context.Background(), scope.namespace, list, scope.kind.listOptions()...)
 					if err != nil {
-						log.Error().Err(err).Msg("Getting next event from ingress watcher failed")
-						break
+						log.Error().Err(err).Msgf("Listing %v for %v failed", scope.kind.name, namespaceDescription(scope.namespace))
+						continue
 					}
 
-					if event == k8s.EventAdded || event == k8s.EventModified {
-						waitGroup.Add(1)
-						status, err := processIngress(dnsService, kubeClient, ingress, fmt.Sprintf("watcher:%v", event))
-						dnsRecordsTotals.With(prometheus.Labels{"namespace": *ingress.Metadata.Namespace, "status": status, "initiator": "watcher", "type": "ingress"}).Inc()
-						waitGroup.Done()
+					items := scope.kind.listItems(list)
+					log.Info().Msgf("Found %v %v", len(items), scope.kind.name)
 
-						if err != nil {
-							log.Error().Err(err).Msgf("Processing ingress %v.%v failed", *ingress.Metadata.Name, *ingress.Metadata.Namespace)
-							continue
-						}
+					for _, item := range items {
+						queue.Add(newWorkItem(scope.kind.kind, item, "poller"))
 					}
 				}
+
+				// sleep random time around 900 seconds
+				sleepTime := foundation.ApplyJitter(900)
+				log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
+				time.Sleep(time.Duration(sleepTime) * time.Second)
 			}
+		}()
 
-			// sleep random time between 22 and 37 seconds
-			sleepTime := foundation.ApplyJitter(30)
-			log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-			time.Sleep(time.Duration(sleepTime) * time.Second)
-		}
-	}(waitGroup)
+		// delete records left behind by objects that no longer claim them
+		if *gcInterval > 0 {
+			if !*gcDryRun && *ownerID == defaultOwnerID {
+				log.Warn().Msgf("Garbage collection only reports orphaned dns records while --owner-id is %v, since clusters sharing the zone would delete each other's records; set a unique --owner-id per cluster to let it delete them", defaultOwnerID)
+			}
 
-	go func(waitGroup *sync.WaitGroup) {
-		// loop indefinitely
-		for {
+			go func() {
+				// loop indefinitely
+				for {
+					// wait first, so objects get synced before their records are checked
+					log.Info().Msgf("Sleeping for %v before garbage collection...", *gcInterval)
+					time.Sleep(*gcInterval)
 
-			// get services for all namespaces
-			log.Info().Msg("Listing services for all namespaces...")
-			var services corev1.ServiceList
-			err := kubeClient.List(context.Background(), k8s.AllNamespaces, &services)
-			if err != nil {
-				log.Error().Err(err).Msg("ListServices call failed")
-			}
-			log.Info().Msgf("Cluster has %v services", len(services.Items))
+					collectOrphanedRecords(dnsService, kubeClient)
+				}
+			}()
+		}
 
-			// loop all services
-			for _, service := range services.Items {
+		// process the queued objects with multiple workers
+		log.Info().Msgf("Starting %v workers...", *workers)
+		for i := 0; i < *workers; i++ {
+			go func(waitGroup *sync.WaitGroup) {
+				for {
+					item, ok := queue.Get()
+					if !ok {
+						return
+					}
 
-				waitGroup.Add(1)
-				status, err := processService(dnsService, kubeClient, service, "poller")
-				dnsRecordsTotals.With(prometheus.Labels{"namespace": *service.Metadata.Namespace, "status": status, "initiator": "poller", "type": "service"}).Inc()
-				waitGroup.Done()
+					waitGroup.Add(1)
+					start := time.Now()
+					status, err := processWorkItem(dnsService, kubeClient, item)
+					processingDuration.With(prometheus.Labels{"status": status, "type": item.Kind}).Observe(time.Since(start).Seconds())
+					dnsRecordsTotals.With(prometheus.Labels{"namespace": item.Namespace, "status": status, "initiator": item.InitiatorType(), "type": item.Kind}).Inc()
 
-				if err != nil {
-					log.Error().Err(err).Msgf("Processing service %v.%v failed", *service.Metadata.Name, *service.Metadata.Namespace)
-					continue
+					if err != nil {
+						log.Error().Err(err).Msgf("Processing %v %v.%v failed, retrying later", item.Kind, item.Name, item.Namespace)
+						queue.AddRateLimited(item)
+					} else {
+						queue.Forget(item)
+					}
+					queue.Done(item)
+					waitGroup.Done()
 				}
-			}
+			}(waitGroup)
+		}
+	}
 
-			// get ingresses for all namespaces
-			log.Info().Msg("Listing ingresses for all namespaces...")
-			var ingresses v1beta1.IngressList
-			err = kubeClient.List(context.Background(), k8s.AllNamespaces, &ingresses)
-			if err != nil {
-				log.Error().Err(err).Msg("ListIngresses call failed")
-			}
-			log.Info().Msgf("Cluster has %v ingresses", len(ingresses.Items))
+	if elector != nil {
+		go elector.Run(startReconciling)
+	} else {
+		isLeader.Set(1)
+		startReconciling()
+	}
 
-			// loop all ingresses
-			for _, ingress := range ingresses.Items {
+	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup, queue.ShutDown)
 
-				waitGroup.Add(1)
-				status, err := processIngress(dnsService, kubeClient, ingress, "poller")
-				dnsRecordsTotals.With(prometheus.Labels{"namespace": *ingress.Metadata.Namespace, "status": status, "initiator": "poller", "type": "ingress"}).Inc()
-				waitGroup.Done()
+	// hand over to a standby replica right away, now the workers are done
+	elector.Release()
+}
 
-				if err != nil {
-					log.Error().Err(err).Msgf("Processing ingress %v.%v failed", *ingress.Metadata.Name, *ingress.Metadata.Namespace)
-					continue
-				}
-			}
+// processWorkItem retrieves the latest version of the object of a work item and processes it
+func processWorkItem(dnsService *GoogleCloudDNSService, client *k8s.Client, item workItem) (status string, err error) {
 
-			// sleep random time around 900 seconds
-			sleepTime := foundation.ApplyJitter(900)
-			log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+	switch item.Kind {
+	case serviceKind.kind:
+		var service corev1.Service
+		err = client.Get(context.Background(), item.Namespace, item.Name, &service)
+		if err == nil {
+			return processService(dnsService, client, &service, item.Initiator)
 		}
-	}(waitGroup)
 
-	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup)
+	case ingressKind.kind:
+		resource := newIngressResource()
+		err = client.Get(context.Background(), item.Namespace, item.Name, resource)
+		if err == nil {
+			return processIngress(dnsService, client, toIngress(resource), item.Initiator)
+		}
+
+	case gatewayKind.kind:
+		var gateway Gateway
+		err = client.Get(context.Background(), item.Namespace, item.Name, &gateway)
+		if err == nil {
+			return processGateway(dnsService, client, &gateway, item.Initiator)
+		}
+
+	case dnsEndpointKind.kind:
+		var endpoint DNSEndpoint
+		err = client.Get(context.Background(), item.Namespace, item.Name, &endpoint)
+		if err == nil {
+			return processDNSEndpoint(dnsService, client, &endpoint, item.Initiator)
+		}
+
+	case nodeKind.kind:
+		var node corev1.Node
+		err = client.Get(context.Background(), k8s.AllNamespaces, item.Name, &node)
+		if err == nil {
+			return processNode(dnsService, client, &node, item.Initiator)
+		}
+
+	default:
+		return "skipped", fmt.Errorf("unknown kind %v", item.Kind)
+	}
+
+	if isAPIError(err, http.StatusNotFound) {
+		// the object has been deleted in the meantime
+		return "skipped", nil
+	}
+
+	return "failed", err
 }
 
-func getDesiredServiceState(service *corev1.Service) (state GoogleCloudDNSState) {
+// getDesiredServiceState returns the desired state of a service, with the ip addresses from its address source comma separated or the
+// external name of an ExternalName service; a *hostnameTemplateError is returned if its hostname template fails, and an
+// *invalidAddressSourceError along with the state if the address source doesn't fit the service
+func getDesiredServiceState(client *k8s.Client, service *corev1.Service) (state GoogleCloudDNSState, err error) {
 
 	var ok bool
 
@@ -242,103 +502,97 @@ func getDesiredServiceState(service *corev1.Service) (state GoogleCloudDNSState)
 	if !ok {
 		state.Enabled = "false"
 	}
-	state.Hostnames, ok = service.Metadata.Annotations[annotationGoogleCloudDNSHostnames]
-	if !ok {
-		state.Hostnames = ""
+	state.Hostnames, err = getDesiredServiceHostnames(service.Metadata)
+	if err != nil {
+		return
 	}
 
-	if *service.Spec.Type == "LoadBalancer" && len(service.Status.LoadBalancer.Ingress) > 0 {
-		state.IPAddress = *service.Status.LoadBalancer.Ingress[0].Ip
+	// addresses are only needed to publish records, not to release them
+	if state.Enabled != "true" || service.Metadata.DeletionTimestamp != nil {
+		return state, nil
 	}
 
-	return
-}
-
-func getCurrentServiceState(service *corev1.Service) (state GoogleCloudDNSState) {
+	// an ExternalName service already names the dns name its records should point to
+	if service.GetSpec().GetType() == "ExternalName" {
+		state.RecordType = "CNAME"
+		state.Target, err = getServiceExternalName(service)
+		return state, err
+	}
 
-	// get state stored in annotations if present or set to empty struct
-	googleCloudDNSStateString, ok := service.Metadata.Annotations[annotationGoogleCloudDNSState]
-	if !ok {
-		// couldn't find saved state, setting to default struct
-		state = GoogleCloudDNSState{}
-		return
+	// the records of a headless service point to its endpoints instead
+	if *enableHeadlessServices && isHeadlessService(service) {
+		return state, nil
 	}
 
-	if err := json.Unmarshal([]byte(googleCloudDNSStateString), &state); err != nil {
-		// couldn't deserialize, setting to default struct
-		state = GoogleCloudDNSState{}
-		return
+	ipAddresses, err := getServiceIPAddresses(client, service)
+	if err != nil {
+		return state, err
 	}
+	state.IPAddress = strings.Join(ipAddresses, ",")
 
-	// return deserialized state
-	return
+	return state, nil
 }
 
-func makeServiceChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, service *corev1.Service, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
-
-	status = "failed"
-	hasChanges := false
-
-	// check if service has estafette.io/google-cloud-dns annotation and it's value is true and
-	// check if service has estafette.io/google-cloud-dns-hostnames annotation and it's value is not empty and
-	// check if type equals LoadBalancer and
-	// check if LoadBalancer has an ip address
-	if desiredState.Enabled == "true" && len(desiredState.Hostnames) > 0 && desiredState.IPAddress != "" {
+// getDesiredServiceHostnames returns the hostnames from the annotation and the hostname template
+func getDesiredServiceHostnames(metadata *metav1.ObjectMeta) (string, error) {
+	return addTemplatedHostnames(metadata.Annotations[annotationGoogleCloudDNSHostnames], metadata)
+}
 
-		// update dns record if anything has changed compared to the stored state
-		if desiredState.IPAddress != currentState.IPAddress ||
-			desiredState.Hostnames != currentState.Hostnames {
+func getCurrentServiceState(client *k8s.Client, service *corev1.Service) (state GoogleCloudDNSState, err error) {
+	return getStoredState(client, "Service", service.Metadata)
+}
 
-			hasChanges = true
+func makeServiceChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, service *corev1.Service, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
+	return makeChanges(dnsService, client, "Service", service, initiator, desiredState, currentState)
+}
 
-			// loop all hostnames
-			hostnames := strings.Split(desiredState.Hostnames, ",")
-			for _, hostname := range hostnames {
+func processService(dnsService *GoogleCloudDNSService, client *k8s.Client, service *corev1.Service, initiator string) (status string, err error) {
 
-				// validate hostname, skip if invalid
-				if !validateHostname(hostname) {
-					log.Error().Err(err).Msgf("[%v] Service %v.%v - Invalid dns record %v, skipping", initiator, *service.Metadata.Name, *service.Metadata.Namespace, hostname)
-					continue
-				}
+	status = "failed"
 
-				log.Info().Msgf("[%v] Service %v.%v - Upserting dns record %v (A) to ip address %v...", initiator, *service.Metadata.Name, *service.Metadata.Namespace, hostname, desiredState.IPAddress)
+	if &service != nil && &service.Metadata != nil && &service.Metadata.Annotations != nil && (isBeingReleased(service.Metadata) || isInScope(service.Metadata) && loadBalancerClassMatches(service)) {
 
-				err := dnsService.UpsertDNSRecord("A", hostname, desiredState.IPAddress)
-				if err != nil {
-					log.Error().Err(err).Msgf("[%v] Service %v.%v - Upserting dns record %v (A) to ip address %v failed", initiator, *service.Metadata.Name, *service.Metadata.Namespace, hostname, desiredState.IPAddress)
-					return status, err
-				}
+		currentState, stateErr := getCurrentServiceState(client, service)
+		if stateErr != nil {
+			return status, stateErr
+		}
+		desiredState, desiredErr := getDesiredServiceState(client, service)
+		switch desiredErr.(type) {
+		case nil:
+		case *hostnameTemplateError:
+			// an object that is being deleted gets its records deleted, whatever its hostname template
+			if service.Metadata.DeletionTimestamp == nil {
+				return rejectHostnameTemplate(client, "Service", service, initiator, currentState, desiredErr)
 			}
+		case *invalidAddressSourceError:
+			return rejectAddressSource(client, service, initiator, currentState, desiredErr)
+		default:
+			return status, desiredErr
 		}
-	}
-
-	if hasChanges {
-
-		// if any state property changed make sure to update all
-		currentState = desiredState
-
-		log.Info().Msgf("[%v] Service %v.%v - Updating service because state has changed...", initiator, *service.Metadata.Name, *service.Metadata.Namespace)
 
-		// serialize state and store it in the annotation
-		googleCloudDNSStateByteArray, err := json.Marshal(currentState)
-		if err != nil {
-			log.Error().Err(err).Msgf("[%v] Service %v.%v - Marshalling state failed", initiator, *service.Metadata.Name, *service.Metadata.Namespace)
-			return status, err
+		if *enableHeadlessServices && isHeadlessService(service) && desiredState.Enabled == "true" && desiredState.Hostnames != "" && service.Metadata.DeletionTimestamp == nil {
+			return makeHeadlessServiceChanges(dnsService, client, service, initiator, desiredState, currentState)
 		}
-		service.Metadata.Annotations[annotationGoogleCloudDNSState] = string(googleCloudDNSStateByteArray)
 
-		// update service, because the state annotations have changed
-		err = client.Update(context.Background(), service)
-		if err != nil {
-			log.Error().Err(err).Msgf("[%v] Service %v.%v - Updating service state has failed", initiator, *service.Metadata.Name, *service.Metadata.Namespace)
-			return status, err
+		if requiresReadyEndpoints(service) && desiredState.Enabled == "true" && service.Metadata.DeletionTimestamp == nil {
+			var withdraw bool
+			var readyErr error
+			desiredState, withdraw, readyErr = applyReadyEndpoints(client, service, desiredState, currentState)
+			switch readyErr.(type) {
+			case nil:
+			case *invalidAddressSourceError:
+				return rejectAddressSource(client, service, initiator, currentState, readyErr)
+			default:
+				return status, readyErr
+			}
+			if withdraw {
+				return withdrawServiceRecords(dnsService, client, service, initiator, desiredState, currentState)
+			}
 		}
 
-		status = "succeeded"
-
-		log.Info().Msgf("[%v] Service %v.%v - Service has been updated successfully...", initiator, *service.Metadata.Name, *service.Metadata.Namespace)
+		status, err = makeServiceChanges(dnsService, client, service, initiator, desiredState, currentState)
 
-		return status, nil
+		return
 	}
 
 	status = "skipped"
@@ -346,26 +600,28 @@ func makeServiceChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, s
 	return status, nil
 }
 
-func processService(dnsService *GoogleCloudDNSService, client *k8s.Client, service *corev1.Service, initiator string) (status string, err error) {
+// rejectAddressSource stores why the address source of a service can't be used in its state and keeps its current records; the service
+// isn't retried until it changes, since retrying doesn't fix its address source
+func rejectAddressSource(client *k8s.Client, service *corev1.Service, initiator string, currentState GoogleCloudDNSState, addressErr error) (status string, err error) {
 
-	status = "failed"
-
-	if &service != nil && &service.Metadata != nil && &service.Metadata.Annotations != nil {
+	// only report and store the error when it changes, so storing it doesn't keep triggering watch events
+	if currentState.LastError == addressErr.Error() {
+		return "skipped", nil
+	}
 
-		desiredState := getDesiredServiceState(service)
-		currentState := getCurrentServiceState(service)
+	log.Warn().Msgf("[%v] Service %v.%v - Skipped publishing dns records: %v", initiator, *service.Metadata.Name, *service.Metadata.Namespace, addressErr)
+	recordEvent(client, "Service", service, eventTypeWarning, eventReasonInvalidAddressSource, "Skipped publishing dns records: %v", addressErr)
 
-		status, err = makeServiceChanges(dnsService, client, service, initiator, desiredState, currentState)
-
-		return
+	currentState.SchemaVersion = stateSchemaVersion
+	currentState.LastError = addressErr.Error()
+	if err = updateState(client, "Service", service, initiator, currentState); err != nil {
+		return "failed", err
 	}
 
-	status = "skipped"
-
-	return status, nil
+	return "skipped", nil
 }
 
-func getDesiredIngressState(ingress *v1beta1.Ingress) (state GoogleCloudDNSState) {
+func getDesiredIngressState(ingress *Ingress) (state GoogleCloudDNSState, err error) {
 
 	var ok bool
 
@@ -378,17 +634,86 @@ func getDesiredIngressState(ingress *v1beta1.Ingress) (state GoogleCloudDNSState
 		state.Hostnames = ""
 	}
 
+	// derive hostnames from the ingress rules and tls hosts if opted in
+	if state.Hostnames == annotationValueAuto {
+		state.Hostnames = joinHostnames(getIngressRuleHostnames(ingress))
+	} else if *ingressHostnamesFromRules {
+		state.Hostnames = joinHostnames(append(strings.Split(state.Hostnames, ","), getIngressRuleHostnames(ingress)...))
+	}
+	state.Hostnames, err = addTemplatedHostnames(state.Hostnames, ingress.Metadata)
+	if err != nil {
+		return
+	}
+
+	// leave out hostnames that are managed elsewhere
+	if excludedHostnames, ok := ingress.Metadata.Annotations[annotationGoogleCloudDNSHostnamesExclude]; ok && excludedHostnames != "" {
+		state.Hostnames = excludeHostnames(state.Hostnames, strings.Split(excludedHostnames, ","))
+	}
+
 	if len(ingress.Status.LoadBalancer.Ingress) > 0 {
-		state.IPAddress = *ingress.Status.LoadBalancer.Ingress[0].Ip
+		state.IPAddress = ingress.Status.LoadBalancer.Ingress[0].IP
 	}
 
 	return
 }
 
-func getCurrentIngressState(ingress *v1beta1.Ingress) (state GoogleCloudDNSState) {
+// getIngressRuleHostnames returns the hosts of the rules and tls sections of an ingress, skipping wildcard hosts
+func getIngressRuleHostnames(ingress *Ingress) (hostnames []string) {
+	for _, rule := range ingress.Spec.Rules {
+		hostnames = append(hostnames, rule.Host)
+	}
+	for _, tls := range ingress.Spec.TLS {
+		hostnames = append(hostnames, tls.Hosts...)
+	}
+
+	filteredHostnames := []string{}
+	for _, hostname := range hostnames {
+		if !strings.HasPrefix(hostname, "*") {
+			filteredHostnames = append(filteredHostnames, hostname)
+		}
+	}
+
+	return filteredHostnames
+}
+
+func getCurrentIngressState(client *k8s.Client, ingress *Ingress) (state GoogleCloudDNSState, err error) {
+	return getStoredState(client, "Ingress", ingress.Metadata)
+}
+
+func makeIngressChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, ingress *Ingress, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
+	return makeChanges(dnsService, client, "Ingress", ingressResource(ingress), initiator, desiredState, currentState)
+}
+
+func processIngress(dnsService *GoogleCloudDNSService, client *k8s.Client, ingress *Ingress, initiator string) (status string, err error) {
+
+	status = "failed"
+
+	if &ingress != nil && &ingress.Metadata != nil && &ingress.Metadata.Annotations != nil && (isBeingReleased(ingress.Metadata) || isInScope(ingress.Metadata) && ingressClassMatches(ingress)) {
+
+		desiredState, templateErr := getDesiredIngressState(ingress)
+		currentState, stateErr := getCurrentIngressState(client, ingress)
+		if stateErr != nil {
+			return status, stateErr
+		}
+		// an object that is being deleted gets its records deleted, whatever its hostname template
+		if templateErr != nil && ingress.Metadata.DeletionTimestamp == nil {
+			return rejectHostnameTemplate(client, "Ingress", ingressResource(ingress), initiator, currentState, templateErr)
+		}
+
+		status, err = makeIngressChanges(dnsService, client, ingress, initiator, desiredState, currentState)
+
+		return
+	}
+
+	status = "skipped"
+
+	return status, nil
+}
+
+func getCurrentState(annotations map[string]string) (state GoogleCloudDNSState) {
 
 	// get state stored in annotations if present or set to empty struct
-	googleCloudDNSStateString, ok := ingress.Metadata.Annotations[annotationGoogleCloudDNSState]
+	googleCloudDNSStateString, ok := annotations[annotationGoogleCloudDNSState]
 	if !ok {
 		// couldn't find saved state, setting to default struct
 		state = GoogleCloudDNSState{}
@@ -401,71 +726,359 @@ func getCurrentIngressState(ingress *v1beta1.Ingress) (state GoogleCloudDNSState
 		return
 	}
 
-	// return deserialized state
-	return
+	// return deserialized state, migrated to the current schema version
+	return migrateState(state)
+}
+
+// migrateState upgrades a state stored by an older version of the controller to the current schema version; the first version
+// only stored enabled, hostnames and ipAddress after a successful sync, so all its records are considered synced
+func migrateState(state GoogleCloudDNSState) GoogleCloudDNSState {
+	if state.SchemaVersion >= stateSchemaVersion {
+		return state
+	}
+
+	if state.SchemaVersion == 0 && state.Hostnames != "" && state.IPAddress != "" {
+		state.Records = []GoogleCloudDNSRecordState{}
+		for _, hostname := range strings.Split(state.Hostnames, ",") {
+			state.Records = append(state.Records, newRecordState(hostname, recordStatusSynced))
+		}
+	}
+	state.SchemaVersion = stateSchemaVersion
+
+	return state
+}
+
+// newRecordState returns the state of the A record of a hostname in the zone of the controller
+func newRecordState(hostname, status string) GoogleCloudDNSRecordState {
+	return GoogleCloudDNSRecordState{
+		Hostname: hostname,
+		Type:     "A",
+		TTL:      dnsRecordTTL,
+		Zone:     *googleCloudDNSZone,
+		Status:   status,
+	}
+}
+
+// getRecordType returns the type of the records of a state, which is A unless the object points to a dns name
+func getRecordType(state GoogleCloudDNSState) string {
+	if state.RecordType == "" {
+		return "A"
+	}
+	return state.RecordType
+}
+
+// getRecordTarget returns what the records of a state point to, the comma-separated ip addresses or the dns name of a CNAME record
+func getRecordTarget(state GoogleCloudDNSState) string {
+	if getRecordType(state) == "CNAME" {
+		return state.Target
+	}
+	return state.IPAddress
 }
 
-func makeIngressChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, ingress *v1beta1.Ingress, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
+// makeChanges upserts the dns records for any kind of resource and stores the new state in its annotation; if upserting fails the
+// error is stored in the state as well, while keeping the last successfully synced hostnames and ip address
+func makeChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, kind string, resource k8s.Resource, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
 
 	status = "failed"
 
-	// check if ingress has estafette.io/google-cloud-dns annotation and it's value is true and
-	// check if ingress has estafette.io/google-cloud-dns-hostnames annotation and it's value is not empty and
-	// check if type equals LoadBalancer and
-	// check if LoadBalancer has an ip address
-	if desiredState.Enabled == "true" && len(desiredState.Hostnames) > 0 && desiredState.IPAddress != "" {
+	metadata := resource.GetMetadata()
+	claim := newHostnameClaim(strings.ToLower(kind), metadata)
 
-		log.Debug().Interface("desiredState", desiredState).Interface("currentState", currentState).Msgf("[%v] Service %v.%v - Comparing current and desired state", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace)
+	// delete the dns records of a resource that is being deleted before letting it go
+	if metadata.DeletionTimestamp != nil {
+		hostnameClaims.Release(claim.item)
+		return releaseResource(dnsService, client, kind, resource, initiator, currentState)
+	}
+
+	// check if resource has estafette.io/google-cloud-dns annotation and it's value is true and
+	// check if resource has estafette.io/google-cloud-dns-hostnames annotation and it's value is not empty and
+	// check if it has an ip address or dns name to point to
+	if desiredState.Enabled == "true" && len(desiredState.Hostnames) > 0 && getRecordTarget(desiredState) != "" {
+
+		hostnames := strings.Split(desiredState.Hostnames, ",")
+		recordType := getRecordType(desiredState)
+		target := getRecordTarget(desiredState)
+		targetChanged := recordType != getRecordType(currentState) || target != getRecordTarget(currentState)
+
+		// hostnames the domain policy rejects don't take part in resolving conflicts
+		allowedHostnames := []string{}
+		for _, hostname := range hostnames {
+			if allowed, err := isHostnameAllowed(client, *metadata.Namespace, hostname); err == nil && allowed {
+				allowedHostnames = append(allowedHostnames, hostname)
+			}
+		}
+		lostHostnames := hostnameClaims.Claim(claim, allowedHostnames)
+
+		log.Debug().Interface("desiredState", desiredState).Interface("currentState", currentState).Msgf("[%v] %v %v.%v - Comparing current and desired state", initiator, kind, *metadata.Name, *metadata.Namespace)
+
+		// update dns record if anything has changed compared to the stored state, or to retry the hostnames that were skipped or the
+		// sync that failed
+		if targetChanged ||
+			desiredState.Hostnames != currentState.Hostnames ||
+			currentState.LastError != "" ||
+			hasSkippedRecords(currentState) ||
+			hasUnownedRecords(currentState) ||
+			hasLostRecords(currentState, lostHostnames) ||
+			hasDeniedRecords(currentState, allowedHostnames) ||
+			desiredState.NotReadySince != currentState.NotReadySince {
+
+			owner := ownerRecordValue(kind, metadata)
+			records := []GoogleCloudDNSRecordState{}
+			changeID := currentState.ChangeID
+
+			previousRecords := map[string]GoogleCloudDNSRecordState{}
+			for _, record := range currentState.Records {
+				previousRecords[record.Hostname] = record
+			}
+
+			// newRecord returns the state of the record of a hostname with the desired type
+			newRecord := func(hostname, status string) GoogleCloudDNSRecordState {
+				record := newRecordState(hostname, status)
+				record.Type = recordType
+				return record
+			}
+
+			// failed stores the error in the state, along with the results of the records handled so far
+			failed := func(record GoogleCloudDNSRecordState, err error) (string, error) {
+				failedState := currentState
+				failedState.SchemaVersion = stateSchemaVersion
+				failedState.Records = append(records, record)
+				failedState.LastError = err.Error()
+				failedState.ChangeID = changeID
+
+				// the state is only stored when the error changes, so storing it doesn't keep triggering watch events
+				if failedState.LastError != currentState.LastError {
+					if stateErr := updateState(client, kind, resource, initiator, failedState); stateErr != nil {
+						log.Warn().Err(stateErr).Msgf("[%v] %v %v.%v - Storing the error in the state failed", initiator, kind, *metadata.Name, *metadata.Namespace)
+					}
+				}
 
-		// update dns record if anything has changed compared to the stored state
-		if desiredState.IPAddress != currentState.IPAddress ||
-			desiredState.Hostnames != currentState.Hostnames {
+				return status, err
+			}
 
 			// loop all hostnames
-			hostnames := strings.Split(desiredState.Hostnames, ",")
 			for _, hostname := range hostnames {
 
+				previousRecord, hasPreviousRecord := previousRecords[hostname]
+				wasInConflict := hasPreviousRecord && previousRecord.Status == recordStatusConflict
+
 				// validate hostname, skip if invalid
 				if !validateHostname(hostname) {
-					log.Error().Err(err).Msgf("[%v] Service %v.%v - Invalid dns record %v, skipping", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace, hostname)
+					log.Error().Err(err).Msgf("[%v] %v %v.%v - Invalid dns record %v, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname)
+					recordEvent(client, kind, resource, eventTypeWarning, eventReasonInvalidHostname, "Skipped invalid hostname %v", hostname)
+					records = append(records, newRecord(hostname, recordStatusInvalid))
 					continue
 				}
 
-				log.Info().Msgf("[%v] Ingress %v.%v - Upserting dns record %v (A) to ip address %v...", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace, hostname, desiredState.IPAddress)
+				// skip hostnames outside of the zone, they can't be set
+				zoneDNSName, err := dnsService.GetZoneDNSName()
+				if err != nil {
+					log.Error().Err(err).Msgf("[%v] %v %v.%v - Retrieving the dns name of zone %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, *googleCloudDNSZone)
+					recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Retrieving the dns name of zone %v failed: %v", *googleCloudDNSZone, err)
+					return failed(newRecord(hostname, recordStatusFailed), err)
+				}
+				if !isHostnameInZone(hostname, zoneDNSName) {
+					log.Error().Msgf("[%v] %v %v.%v - Dns record %v isn't within zone %v, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, zoneDNSName)
+					if !(hasPreviousRecord && previousRecord.Status == recordStatusInvalid) {
+						recordEvent(client, kind, resource, eventTypeWarning, eventReasonInvalidHostname, "Skipped hostname %v, it isn't within zone %v", hostname, zoneDNSName)
+					}
+					records = append(records, newRecord(hostname, recordStatusInvalid))
+					continue
+				}
 
-				err := dnsService.UpsertDNSRecord("A", hostname, desiredState.IPAddress)
+				// skip hostnames the domain policy doesn't allow the namespace to claim
+				allowed, err := isHostnameAllowed(client, *metadata.Namespace, hostname)
 				if err != nil {
-					log.Error().Err(err).Msgf("[%v] Ingress %v.%v - Upserting dns record %v (A) to ip address %v failed", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace, hostname, desiredState.IPAddress)
-					return status, err
+					log.Error().Err(err).Msgf("[%v] %v %v.%v - Checking the domain policy for hostname %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, hostname)
+					recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Checking the domain policy for hostname %v failed: %v", hostname, err)
+					return failed(newRecord(hostname, recordStatusFailed), err)
+				}
+				if !allowed {
+					log.Warn().Msgf("[%v] %v %v.%v - Hostname %v isn't allowed for namespace %v by the domain policy, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, *metadata.Namespace)
+					if !(hasPreviousRecord && previousRecord.Status == recordStatusDenied) {
+						recordEvent(client, kind, resource, eventTypeWarning, eventReasonPolicyViolation, "Rejected hostname %v, the domain policy doesn't allow namespace %v to claim it", hostname, *metadata.Namespace)
+						policyViolationTotals.With(prometheus.Labels{"namespace": *metadata.Namespace, "type": claim.item.Kind}).Inc()
+					}
+
+					// a record set before the policy denied the hostname is deleted; if that fails it's kept in the state, so it's retried
+					if hasPreviousRecord && isSyncedRecordStatus(previousRecord.Status) {
+						log.Info().Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) denied by the domain policy...", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, previousRecord.Type)
+
+						deleted, recordChangeID, err := dnsService.DeleteDNSRecord(previousRecord.Type, hostname, owner)
+						if err != nil {
+							log.Error().Err(err).Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) failed", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, previousRecord.Type)
+							recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Deleting dns record %v (%v) denied by the domain policy failed: %v", hostname, previousRecord.Type, err)
+							return failed(previousRecord, err)
+						}
+						if deleted {
+							changeID = recordChangeID
+							recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordDeleted, "Deleted dns record %v (%v), the domain policy doesn't allow namespace %v to claim it", hostname, previousRecord.Type, *metadata.Namespace)
+						}
+					}
+
+					records = append(records, newRecord(hostname, recordStatusDenied))
+					continue
+				}
+
+				// skip hostnames claimed by another object that takes precedence
+				if winner, ok := lostHostnames[hostname]; ok {
+					log.Warn().Msgf("[%v] %v %v.%v - Hostname %v is claimed by %v %v.%v as well, which takes precedence, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, winner.item.Kind, winner.item.Name, winner.item.Namespace)
+					if !wasInConflict {
+						recordEvent(client, kind, resource, eventTypeWarning, eventReasonHostnameConflict, "Skipped hostname %v, it's claimed by %v %v.%v as well, which takes precedence", hostname, winner.item.Kind, winner.item.Name, winner.item.Namespace)
+						hostnameConflictTotals.With(prometheus.Labels{"namespace": *metadata.Namespace, "type": claim.item.Kind}).Inc()
+					}
+					records = append(records, newRecord(hostname, recordStatusConflict))
+					continue
+				}
+
+				// a CNAME record can't be set at the apex of the zone
+				if recordType == "CNAME" && isZoneApex(hostname, zoneDNSName) {
+					log.Error().Msgf("[%v] %v %v.%v - Dns record %v is the apex of zone %v, which can't have a CNAME record, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, zoneDNSName)
+					if !(hasPreviousRecord && previousRecord.Status == recordStatusInvalid) {
+						recordEvent(client, kind, resource, eventTypeWarning, eventReasonInvalidHostname, "Skipped hostname %v, it's the apex of zone %v, which can't have a CNAME record", hostname, zoneDNSName)
+					}
+					records = append(records, newRecord(hostname, recordStatusInvalid))
+					continue
+				}
+
+				// keep records that are already set to the target; migrated records get the owner record they lack first, so garbage
+				// collection can find them
+				if hasPreviousRecord && isSyncedRecordStatus(previousRecord.Status) && previousRecord.Type == recordType && !targetChanged {
+					if previousRecord.Status == recordStatusSynced {
+						owned, recordChangeID, err := dnsService.UpsertDNSRecordOwner(recordType, hostname, owner)
+						if err != nil {
+							log.Error().Err(err).Msgf("[%v] %v %v.%v - Writing owner of dns record %v (%v) failed", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, recordType)
+							recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Writing owner of dns record %v (%v) failed: %v", hostname, recordType, err)
+							return failed(previousRecord, err)
+						}
+						if !owned {
+							log.Warn().Msgf("[%v] %v %v.%v - Dns record %v is owned by another object or controller instance, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname)
+							recordEvent(client, kind, resource, eventTypeWarning, eventReasonOwnershipConflict, "Skipped hostname %v, its dns record is owned by another object or controller instance", hostname)
+							records = append(records, newRecord(hostname, recordStatusConflict))
+							continue
+						}
+						if recordChangeID != "" {
+							changeID = recordChangeID
+						}
+						previousRecord.Status = recordStatusOwned
+					}
+					records = append(records, previousRecord)
+					continue
 				}
+
+				// skip hostnames whose records are owned by another controller instance; records owned by another object of this
+				// controller are taken over, since this object takes precedence
+				currentOwner, err := dnsService.GetDNSRecordOwner(hostname)
+				if err != nil {
+					log.Error().Err(err).Msgf("[%v] %v %v.%v - Retrieving owner of dns record %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, hostname)
+					recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Retrieving owner of dns record %v failed: %v", hostname, err)
+					return failed(newRecord(hostname, recordStatusFailed), err)
+				}
+				if isOwnershipConflict(currentOwner, owner) && !isOwnedByThisController(currentOwner) {
+					log.Warn().Msgf("[%v] %v %v.%v - Dns record %v is owned by %v, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, currentOwner)
+					if !wasInConflict {
+						recordEvent(client, kind, resource, eventTypeWarning, eventReasonOwnershipConflict, "Skipped hostname %v, its dns record is owned by %v", hostname, currentOwner)
+					}
+					records = append(records, newRecord(hostname, recordStatusConflict))
+					continue
+				}
+
+				// a record of another type is deleted first, since a CNAME record can't exist next to other records
+				if hasPreviousRecord && isSyncedRecordStatus(previousRecord.Status) && previousRecord.Type != recordType {
+					log.Info().Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) to replace it with a %v record...", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, previousRecord.Type, recordType)
+
+					_, recordChangeID, err := dnsService.DeleteDNSRecord(previousRecord.Type, hostname, owner)
+					if err != nil {
+						log.Error().Err(err).Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) failed", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, previousRecord.Type)
+						recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Deleting dns record %v (%v) to replace it with a %v record failed: %v", hostname, previousRecord.Type, recordType, err)
+						return failed(previousRecord, err)
+					}
+					if recordChangeID != "" {
+						changeID = recordChangeID
+					}
+				}
+
+				log.Info().Msgf("[%v] %v %v.%v - Upserting dns record %v (%v) to %v...", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, recordType, target)
+
+				created, recordChangeID, err := dnsService.UpsertDNSRecordSet(recordType, hostname, dnsRecordTTL, strings.Split(target, ","), owner)
+				if err != nil {
+					log.Error().Err(err).Msgf("[%v] %v %v.%v - Upserting dns record %v (%v) to %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, hostname, recordType, target)
+					recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Upserting dns record %v (%v) to %v failed: %v", hostname, recordType, target, err)
+					return failed(newRecord(hostname, recordStatusFailed), err)
+				}
+				changeID = recordChangeID
+
+				record := newRecord(hostname, recordStatusUpdated)
+				record.ChangeID = recordChangeID
+				if created {
+					record.Status = recordStatusCreated
+					recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordCreated, "Created dns record %v (%v) pointing to %v", hostname, recordType, target)
+				} else {
+					recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordUpdated, "Updated dns record %v (%v) to %v", hostname, recordType, target)
+				}
+				records = append(records, record)
+			}
+
+			// nothing changed if retrying the skipped hostnames didn't resolve any of them
+			if desiredState.Enabled == currentState.Enabled && desiredState.Hostnames == currentState.Hostnames && !targetChanged && desiredState.NotReadySince == currentState.NotReadySince &&
+				currentState.LastError == "" && reflect.DeepEqual(records, currentState.Records) && hasFinalizer(metadata) {
+				return "skipped", nil
 			}
 
 			// if any state property changed make sure to update all
 			currentState = desiredState
+			currentState.SchemaVersion = stateSchemaVersion
+			currentState.Records = records
+			currentState.LastSyncTime = time.Now().UTC().Format(time.RFC3339)
+			currentState.LastError = ""
+			currentState.ChangeID = changeID
+
+			log.Info().Msgf("[%v] %v %v.%v - Updating %v because state has changed...", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
 
-			log.Info().Msgf("[%v] Ingress %v.%v - Updating ingress because state has changed...", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace)
+			// make sure the records get deleted along with the resource
+			addFinalizer(metadata)
 
-			// serialize state and store it in the annotation
-			googleCloudDNSStateByteArray, err := json.Marshal(currentState)
+			err = updateState(client, kind, resource, initiator, currentState)
 			if err != nil {
-				log.Error().Err(err).Msgf("[%v] Ingress %v.%v - Marshalling state failed", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace)
+				recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Storing the dns state in the %v annotation failed: %v", annotationGoogleCloudDNSState, err)
 				return status, err
 			}
-			ingress.Metadata.Annotations[annotationGoogleCloudDNSState] = string(googleCloudDNSStateByteArray)
 
-			// update ingress, because the state annotations have changed
-			err = client.Update(context.Background(), ingress)
+			status = "succeeded"
+
+			log.Info().Msgf("[%v] %v %v.%v - %v has been updated successfully...", initiator, kind, *metadata.Name, *metadata.Namespace, kind)
+
+			return status, nil
+		}
+
+		// add the finalizer to resources synced by a version of the controller without finalizers
+		if !hasFinalizer(metadata) && len(getSyncedHostnames(currentState)) > 0 {
+			log.Info().Msgf("[%v] %v %v.%v - Adding finalizer...", initiator, kind, *metadata.Name, *metadata.Namespace)
+
+			addFinalizer(metadata)
+			err = updateFinalizers(client, kind, resource, initiator)
 			if err != nil {
-				log.Error().Err(err).Msgf("[%v] Ingress %v.%v - Updating ingress state has failed", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace)
 				return status, err
 			}
 
-			status = "succeeded"
+			return "succeeded", nil
+		}
+	}
 
-			log.Info().Msgf("[%v] Ingress %v.%v - Ingress has been updated successfully...", initiator, *ingress.Metadata.Name, *ingress.Metadata.Namespace)
+	// a resource that doesn't publish any hostname gives up its claims
+	hostnameClaims.Release(claim.item)
 
-			return status, nil
+	// a resource that no longer has dns records managed doesn't need the finalizer anymore
+	if desiredState.Enabled != "true" && hasFinalizer(metadata) {
+		log.Info().Msgf("[%v] %v %v.%v - Removing finalizer because dns is disabled...", initiator, kind, *metadata.Name, *metadata.Namespace)
+
+		removeFinalizer(metadata)
+		err = updateFinalizers(client, kind, resource, initiator)
+		if err != nil {
+			return status, err
 		}
+
+		return "succeeded", nil
 	}
 
 	status = "skipped"
@@ -473,23 +1086,145 @@ func makeIngressChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, i
 	return status, nil
 }
 
-func processIngress(dnsService *GoogleCloudDNSService, client *k8s.Client, ingress *v1beta1.Ingress, initiator string) (status string, err error) {
+// isSyncedRecordStatus returns true if the status of a record means it's set in the zone
+func isSyncedRecordStatus(status string) bool {
+	return status == recordStatusCreated || status == recordStatusUpdated || status == recordStatusSynced || status == recordStatusOwned
+}
 
-	status = "failed"
+// hasUnownedRecords returns true if any of the records in the state have been migrated from an older state and still lack the owner
+// record that lets garbage collection find them
+func hasUnownedRecords(state GoogleCloudDNSState) bool {
+	for _, record := range state.Records {
+		if record.Status == recordStatusSynced {
+			return true
+		}
+	}
+	return false
+}
 
-	if &ingress != nil && &ingress.Metadata != nil && &ingress.Metadata.Annotations != nil {
+// hasSkippedRecords returns true if any of the records in the state have been skipped because of a conflict or the domain policy,
+// which may have been resolved in the meantime
+func hasSkippedRecords(state GoogleCloudDNSState) bool {
+	for _, record := range state.Records {
+		if record.Status == recordStatusConflict || record.Status == recordStatusDenied {
+			return true
+		}
+	}
+	return false
+}
 
-		desiredState := getDesiredIngressState(ingress)
-		currentState := getCurrentIngressState(ingress)
+// hasDeniedRecords returns true if any of the records in the state is set for a hostname the domain policy doesn't allow anymore
+func hasDeniedRecords(state GoogleCloudDNSState, allowedHostnames []string) bool {
+	for _, record := range state.Records {
+		if isSyncedRecordStatus(record.Status) && !foundation.StringArrayContains(allowedHostnames, record.Hostname) {
+			return true
+		}
+	}
+	return false
+}
 
-		status, err = makeIngressChanges(dnsService, client, ingress, initiator, desiredState, currentState)
+// hasLostRecords returns true if any of the records in the state is set for a hostname that another object takes precedence for
+func hasLostRecords(state GoogleCloudDNSState, lostHostnames map[string]hostnameClaim) bool {
+	for _, record := range state.Records {
+		if _, ok := lostHostnames[record.Hostname]; ok && isSyncedRecordStatus(record.Status) {
+			return true
+		}
+	}
+	return false
+}
 
-		return
+// updateState serializes the state into the state annotation of the resource and patches the resource with it, along with the
+// finalizer; with the custom resource state store the state is stored in the dns record state of the resource instead
+func updateState(client *k8s.Client, kind string, resource k8s.Resource, initiator string, state GoogleCloudDNSState) error {
+
+	metadata := resource.GetMetadata()
+
+	// the state of a dns endpoint is written to its status
+	if endpoint, ok := resource.(*DNSEndpoint); ok {
+		return updateDNSEndpointStatus(client, endpoint, initiator, state)
 	}
 
-	status = "skipped"
+	if *stateStore == stateStoreCustomResource && strings.ToLower(kind) != nodeKind.kind {
+		err := storeStateResource(client, kind, metadata, state)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Storing %v state in dns record state has failed", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+			return err
+		}
 
-	return status, nil
+		// a state annotation stored before switching to the custom resource is removed along with patching the finalizer
+		var annotations map[string]*string
+		if _, ok := metadata.Annotations[annotationGoogleCloudDNSState]; ok {
+			annotations = map[string]*string{annotationGoogleCloudDNSState: nil}
+		}
+
+		err = patchMetadata(client, kind, resource, annotations)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Updating %v finalizers has failed", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+			return err
+		}
+
+		delete(metadata.Annotations, annotationGoogleCloudDNSState)
+
+		return nil
+	}
+
+	// serialize state and store it in the annotation
+	googleCloudDNSStateByteArray, err := json.Marshal(state)
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] %v %v.%v - Marshalling state failed", initiator, kind, *metadata.Name, *metadata.Namespace)
+		return err
+	}
+	metadata.Annotations[annotationGoogleCloudDNSState] = string(googleCloudDNSStateByteArray)
+
+	// patch resource, because the state annotations have changed
+	err = patchMetadata(client, kind, resource, map[string]*string{annotationGoogleCloudDNSState: k8s.String(metadata.Annotations[annotationGoogleCloudDNSState])})
+	if err != nil {
+		log.Error().Err(err).Msgf("[%v] %v %v.%v - Updating %v state has failed", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+		return err
+	}
+
+	return nil
+}
+
+// joinHostnames returns the hostnames as a comma-separated list, without empty entries and duplicates
+func joinHostnames(hostnames []string) string {
+	joined := []string{}
+	for _, hostname := range hostnames {
+		hostname = strings.TrimSpace(hostname)
+		if hostname != "" && !foundation.StringArrayContains(joined, hostname) {
+			joined = append(joined, hostname)
+		}
+	}
+	return strings.Join(joined, ",")
+}
+
+// excludeHostnames removes the excluded hostnames from a comma-separated list of hostnames
+func excludeHostnames(hostnames string, excludedHostnames []string) string {
+	for i := range excludedHostnames {
+		excludedHostnames[i] = strings.TrimSpace(excludedHostnames[i])
+	}
+
+	remaining := []string{}
+	for _, hostname := range strings.Split(hostnames, ",") {
+		if !foundation.StringArrayContains(excludedHostnames, strings.TrimSpace(hostname)) {
+			remaining = append(remaining, hostname)
+		}
+	}
+
+	return joinHostnames(remaining)
+}
+
+// isHostnameInZone returns true if the hostname is within the dns name of the zone, like example.com.
+func isHostnameInZone(hostname, zoneDNSName string) bool {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	zoneName := strings.ToLower(strings.Trim(zoneDNSName, "."))
+
+	return zoneName == "" || hostname == zoneName || strings.HasSuffix(hostname, "."+zoneName)
+}
+
+// isZoneApex returns true if the hostname is the dns name of the zone itself
+func isZoneApex(hostname, zoneDNSName string) bool {
+	return strings.ToLower(strings.TrimSuffix(hostname, ".")) == strings.ToLower(strings.Trim(zoneDNSName, "."))
 }
 
 func validateHostname(hostname string) bool {
@@ -498,6 +1233,10 @@ func validateHostname(hostname string) bool {
 	if len(dnsNameParts) < 2 {
 		return false
 	}
+	// the names of owner records are reserved for the controller
+	if isOwnerRecordName(hostname) {
+		return false
+	}
 	// each label needs to be max 63 characters
 	for _, label := range dnsNameParts {
 		if len(label) > 63 {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func TestMigrateState(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		state GoogleCloudDNSState
+		want  GoogleCloudDNSState
+	}{
+		{
+			name:  "synced state without a schema version",
+			state: GoogleCloudDNSState{Enabled: "true", Hostnames: "a.example.com,b.example.com", IPAddress: "10.0.0.1"},
+			want: GoogleCloudDNSState{
+				SchemaVersion: stateSchemaVersion,
+				Enabled:       "true",
+				Hostnames:     "a.example.com,b.example.com",
+				IPAddress:     "10.0.0.1",
+				Records: []GoogleCloudDNSRecordState{
+					newRecordState("a.example.com", recordStatusSynced),
+					newRecordState("b.example.com", recordStatusSynced),
+				},
+			},
+		},
+		{
+			name:  "state without a schema version or ip address",
+			state: GoogleCloudDNSState{Enabled: "true", Hostnames: "a.example.com"},
+			want:  GoogleCloudDNSState{SchemaVersion: stateSchemaVersion, Enabled: "true", Hostnames: "a.example.com"},
+		},
+		{
+			name:  "empty state",
+			state: GoogleCloudDNSState{},
+			want:  GoogleCloudDNSState{SchemaVersion: stateSchemaVersion},
+		},
+		{
+			name: "state with the current schema version",
+			state: GoogleCloudDNSState{
+				SchemaVersion: stateSchemaVersion,
+				Hostnames:     "a.example.com",
+				IPAddress:     "10.0.0.1",
+				Records:       []GoogleCloudDNSRecordState{newRecordState("a.example.com", recordStatusConflict)},
+				LastError:     "failed",
+			},
+			want: GoogleCloudDNSState{
+				SchemaVersion: stateSchemaVersion,
+				Hostnames:     "a.example.com",
+				IPAddress:     "10.0.0.1",
+				Records:       []GoogleCloudDNSRecordState{newRecordState("a.example.com", recordStatusConflict)},
+				LastError:     "failed",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := migrateState(tt.state); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: migrateState() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsZoneApex(t *testing.T) {
+
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"example.com", true},
+		{"Example.com.", true},
+		{"www.example.com", false},
+		{"myexample.com", false},
+		{"example.org", false},
+	}
+
+	for _, tt := range tests {
+		if got := isZoneApex(tt.hostname, "example.com."); got != tt.want {
+			t.Errorf("isZoneApex(%q) = %v, want %v", tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestGetIngressRuleHostnames(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		rules []IngressRule
+		tls   []IngressTLS
+		want  []string
+	}{
+		{"no rules", nil, nil, []string{}},
+		{"rule hosts", []IngressRule{{Host: "a.example.com"}, {Host: "b.example.com"}}, nil, []string{"a.example.com", "b.example.com"}},
+		{"tls hosts", nil, []IngressTLS{{Hosts: []string{"a.example.com", "b.example.com"}}}, []string{"a.example.com", "b.example.com"}},
+		{"wildcard hosts are skipped", []IngressRule{{Host: "*.example.com"}, {Host: "a.example.com"}}, []IngressTLS{{Hosts: []string{"*.example.com"}}}, []string{"a.example.com"}},
+		{"duplicate hosts are left to joinHostnames", []IngressRule{{Host: "a.example.com"}}, []IngressTLS{{Hosts: []string{"a.example.com"}}}, []string{"a.example.com", "a.example.com"}},
+	}
+
+	for _, tt := range tests {
+		ingress := &Ingress{Spec: IngressSpec{Rules: tt.rules, TLS: tt.tls}}
+		if got := getIngressRuleHostnames(ingress); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: getIngressRuleHostnames() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetDesiredIngressStateHostnames(t *testing.T) {
+
+	defaultIngressHostnamesFromRules := *ingressHostnamesFromRules
+	defer func() { *ingressHostnamesFromRules = defaultIngressHostnamesFromRules }()
+
+	spec := IngressSpec{
+		Rules: []IngressRule{{Host: "a.example.com"}, {Host: "*.example.com"}, {Host: "b.example.com"}, {}},
+		TLS:   []IngressTLS{{Hosts: []string{"b.example.com", "c.example.com"}}},
+	}
+
+	tests := []struct {
+		name      string
+		fromRules bool
+		hostnames string
+		exclude   string
+		want      string
+	}{
+		{"hostnames annotation only", false, "x.example.com", "", "x.example.com"},
+		{"auto uses the rule and tls hosts without duplicates", false, "auto", "", "a.example.com,b.example.com,c.example.com"},
+		{"auto with excluded hosts", false, "auto", "b.example.com, c.example.com", "a.example.com"},
+		{"flag adds the rule hosts to the annotation", true, "x.example.com,a.example.com", "", "x.example.com,a.example.com,b.example.com,c.example.com"},
+		{"flag with excluded hosts", true, "x.example.com", "a.example.com", "x.example.com,b.example.com,c.example.com"},
+		{"excluded hosts without rules", false, "x.example.com,y.example.com", "y.example.com", "x.example.com"},
+	}
+
+	for _, tt := range tests {
+		*ingressHostnamesFromRules = tt.fromRules
+		annotations := map[string]string{
+			annotationGoogleCloudDNS:          "true",
+			annotationGoogleCloudDNSHostnames: tt.hostnames,
+		}
+		if tt.exclude != "" {
+			annotations[annotationGoogleCloudDNSHostnamesExclude] = tt.exclude
+		}
+		ingress := &Ingress{Metadata: &metav1.ObjectMeta{Name: k8s.String("shop"), Namespace: k8s.String("default"), Annotations: annotations}, Spec: spec}
+
+		state, err := getDesiredIngressState(ingress)
+		if err != nil {
+			t.Errorf("%v: getDesiredIngressState() error = %v", tt.name, err)
+			continue
+		}
+		if state.Hostnames != tt.want {
+			t.Errorf("%v: getDesiredIngressState() hostnames = %q, want %q", tt.name, state.Hostnames, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+// mergePatchRetries is the number of times a patch is retried when the resource has been changed since it was read
+const mergePatchRetries = 5
+
+// metadataPatch is a json merge patch that only touches the annotations and finalizers it carries
+type metadataPatch struct {
+	Metadata metadataPatchFields `json:"metadata"`
+}
+
+type metadataPatchFields struct {
+	// ResourceVersion makes the api server reject the patch with a conflict if the resource changed since it was read, because a
+	// merge patch replaces the finalizers as a whole
+	ResourceVersion string `json:"resourceVersion"`
+	// Annotations holds the annotations to set; a nil value removes the annotation
+	Annotations map[string]*string `json:"annotations,omitempty"`
+	Finalizers  []string           `json:"finalizers"`
+}
+
+// patchMetadata writes annotations and the presence of the finalizer of a resource with a json merge patch, so the spec and the
+// annotations and finalizers of others aren't overwritten; on a conflict the latest finalizers are retrieved and the patch is retried
+func patchMetadata(client *k8s.Client, kind string, resource k8s.Resource, annotations map[string]*string) error {
+
+	metadata := resource.GetMetadata()
+	keepFinalizer := hasFinalizer(metadata)
+	latest := metadata
+
+	for attempt := 1; ; attempt++ {
+		patched := &metav1.ObjectMeta{Finalizers: append([]string{}, latest.Finalizers...)}
+		if keepFinalizer {
+			addFinalizer(patched)
+		} else {
+			removeFinalizer(patched)
+		}
+
+		patch := metadataPatch{
+			Metadata: metadataPatchFields{
+				ResourceVersion: latest.GetResourceVersion(),
+				Annotations:     annotations,
+				Finalizers:      patched.Finalizers,
+			},
+		}
+
+		patchedMetadata, err := mergePatch(client, kind, metadata.GetNamespace(), metadata.GetName(), patch)
+		if err == nil {
+			// keep the resource in line with the patched object, so a later patch of it doesn't run into a conflict
+			metadata.ResourceVersion = patchedMetadata.ResourceVersion
+			metadata.Finalizers = patchedMetadata.Finalizers
+			return nil
+		}
+		if !isAPIError(err, http.StatusConflict) || attempt >= mergePatchRetries {
+			return err
+		}
+
+		latest, _, err = getOwningObjectMetadata(client, strings.ToLower(kind), metadata.GetNamespace(), metadata.GetName())
+		if err != nil {
+			return err
+		}
+		if latest == nil {
+			return fmt.Errorf("%v %v.%v no longer exists", strings.ToLower(kind), metadata.GetName(), metadata.GetNamespace())
+		}
+	}
+}
+
+// mergePatch sends a json merge patch for an object and returns the metadata of the patched object; the kubernetes client has no
+// support for patches, so the request is made with its endpoint, http client and headers
+func mergePatch(client *k8s.Client, kind, namespace, name string, patch interface{}) (*metav1.ObjectMeta, error) {
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	apiVersion := involvedObjectAPIVersion(kind)
+	apiPrefix := "apis"
+	if !strings.Contains(apiVersion, "/") {
+		apiPrefix = "api"
+	}
+	url := fmt.Sprintf("%v/%v/%v/namespaces/%v/%v/%v", strings.TrimSuffix(client.Endpoint, "/"), apiPrefix, apiVersion, namespace, resourceName(kind), name)
+	if namespace == "" {
+		// cluster-scoped objects like nodes aren't within a namespace
+		url = fmt.Sprintf("%v/%v/%v/%v/%v", strings.TrimSuffix(client.Endpoint, "/"), apiPrefix, apiVersion, resourceName(kind), name)
+	}
+
+	request, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(context.Background())
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+	request.Header.Set("Accept", "application/json")
+	if client.SetHeaders != nil {
+		if err := client.SetHeaders(request.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient := client.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// errors are returned the same way the kubernetes client returns them, so they can be checked with isAPIError
+	if response.StatusCode/100 != 2 {
+		status := &metav1.Status{}
+		if err := json.Unmarshal(body, status); err != nil {
+			return nil, fmt.Errorf("patching %v %v.%v failed with status %v", strings.ToLower(kind), name, namespace, response.StatusCode)
+		}
+		return nil, &k8s.APIError{Status: status, Code: response.StatusCode}
+	}
+
+	var patched struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &patched); err != nil {
+		return nil, err
+	}
+
+	return &patched.Metadata, nil
+}
+
+// resourceName returns the plural name of the kinds of objects the controller patches, as used in api paths
+func resourceName(kind string) string {
+	switch strings.ToLower(kind) {
+	case ingressKind.kind:
+		return "ingresses"
+	case gatewayKind.kind:
+		return "gateways"
+	case dnsEndpointKind.kind:
+		return "dnsendpoints"
+	case nodeKind.kind:
+		return "nodes"
+	default:
+		return "services"
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// isNodeEnabled returns true if a node has the dns annotation set to true, or doesn't have it and matches the --node-selector
+func isNodeEnabled(metadata *metav1.ObjectMeta) bool {
+	if enabled, ok := metadata.Annotations[annotationGoogleCloudDNS]; ok {
+		return enabled == "true"
+	}
+	return *nodeSelector != "" && labelSelectorMatches(*nodeSelector, metadata.Labels)
+}
+
+// getDesiredNodeHostnames returns the hostnames from the annotation and the hostname template of a node, which defaults to the
+// --node-hostname-template flag; a *hostnameTemplateError is returned if the template fails
+func getDesiredNodeHostnames(metadata *metav1.ObjectMeta) (string, error) {
+	templatedHostnames, err := renderHostnameTemplate(metadata, defaultNodeHostnameTemplate)
+	if err != nil {
+		return "", err
+	}
+	return joinHostnames(append(strings.Split(metadata.Annotations[annotationGoogleCloudDNSHostnames], ","), templatedHostnames...)), nil
+}
+
+func processNode(dnsService *GoogleCloudDNSService, client *k8s.Client, node *corev1.Node, initiator string) (status string, err error) {
+
+	if node == nil || node.Metadata == nil {
+		return "skipped", nil
+	}
+
+	kind := "Node"
+	metadata := node.Metadata
+
+	// nodes aren't within a namespace; an empty one lets them share the code with namespaced objects
+	if metadata.Namespace == nil {
+		metadata.Namespace = k8s.String("")
+	}
+	if metadata.Annotations == nil {
+		metadata.Annotations = map[string]string{}
+	}
+
+	currentState, err := getStoredState(client, kind, metadata)
+	if err != nil {
+		return "failed", err
+	}
+
+	// delete the dns records of a node that is being deleted before letting it go
+	if metadata.DeletionTimestamp != nil {
+		hostnameClaims.Release(newHostnameClaim(nodeKind.kind, metadata).item)
+		return releaseResource(dnsService, client, kind, node, initiator, currentState)
+	}
+
+	// the hostname template only matters for nodes with dns enabled, so a broken default template isn't reported on every node
+	hostnames := ""
+	if isNodeEnabled(metadata) {
+		var templateErr error
+		hostnames, templateErr = getDesiredNodeHostnames(metadata)
+		if templateErr != nil {
+			return rejectHostnameTemplate(client, kind, node, initiator, currentState, templateErr)
+		}
+	}
+
+	// the records of a node that no longer has dns enabled are deleted right away, since nodes come and go
+	if hostnames == "" {
+		if len(getSyncedHostnames(currentState)) == 0 {
+			hostnameClaims.Release(newHostnameClaim(nodeKind.kind, metadata).item)
+			return "skipped", nil
+		}
+
+		log.Info().Msgf("[%v] %v %v - Deleting dns records because dns is disabled...", initiator, kind, *metadata.Name)
+
+		return makeRecordSetChanges(dnsService, client, kind, node, initiator, nil, currentState, true)
+	}
+
+	records := getNodeRecords(strings.Split(hostnames, ","), node)
+
+	return makeRecordSetChanges(dnsService, client, kind, node, initiator, records, currentState, true)
+}
+
+// getNodeRecords returns an A record with the external ipv4 addresses and an AAAA record with the external ipv6 addresses of a node for
+// each of its hostnames
+func getNodeRecords(hostnames []string, node *corev1.Node) (records []DNSEndpointRecord) {
+
+	targets := map[string][]string{}
+	for _, address := range node.GetStatus().GetAddresses() {
+		if address.GetType() != "ExternalIP" {
+			continue
+		}
+
+		ip := net.ParseIP(address.GetAddress())
+		if ip == nil {
+			continue
+		}
+
+		recordType := "AAAA"
+		if ip.To4() != nil {
+			recordType = "A"
+		}
+		targets[recordType] = appendUnique(targets[recordType], address.GetAddress())
+	}
+
+	for _, hostname := range hostnames {
+		for _, recordType := range []string{"A", "AAAA"} {
+			if len(targets[recordType]) == 0 {
+				continue
+			}
+
+			recordTargets := append([]string{}, targets[recordType]...)
+			sort.Strings(recordTargets)
+
+			records = append(records, DNSEndpointRecord{
+				Name:    hostname,
+				Type:    recordType,
+				TTL:     dnsRecordTTL,
+				Targets: recordTargets,
+			})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func TestIsNodeEnabled(t *testing.T) {
+
+	defaultNodeSelector := *nodeSelector
+	defer func() { *nodeSelector = defaultNodeSelector }()
+
+	tests := []struct {
+		name        string
+		selector    string
+		annotations map[string]string
+		labels      map[string]string
+		want        bool
+	}{
+		{"no annotation and no selector", "", nil, map[string]string{"pool": "ingress"}, false},
+		{"annotation", "", map[string]string{annotationGoogleCloudDNS: "true"}, nil, true},
+		{"matching selector", "pool=ingress", nil, map[string]string{"pool": "ingress"}, true},
+		{"selector that doesn't match", "pool=ingress", nil, map[string]string{"pool": "default"}, false},
+		{"annotation disables a matching node", "pool=ingress", map[string]string{annotationGoogleCloudDNS: "false"}, map[string]string{"pool": "ingress"}, false},
+		{"annotation enables a node the selector doesn't match", "pool=ingress", map[string]string{annotationGoogleCloudDNS: "true"}, map[string]string{"pool": "default"}, true},
+	}
+
+	for _, tt := range tests {
+		*nodeSelector = tt.selector
+		metadata := &metav1.ObjectMeta{Name: k8s.String("node-1"), Annotations: tt.annotations, Labels: tt.labels}
+		if got := isNodeEnabled(metadata); got != tt.want {
+			t.Errorf("%v: isNodeEnabled() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetNodeRecords(t *testing.T) {
+
+	node := func(addresses ...*corev1.NodeAddress) *corev1.Node {
+		return &corev1.Node{
+			Metadata: &metav1.ObjectMeta{Name: k8s.String("node-1")},
+			Status:   &corev1.NodeStatus{Addresses: addresses},
+		}
+	}
+	address := func(addressType, ip string) *corev1.NodeAddress {
+		return &corev1.NodeAddress{Type: k8s.String(addressType), Address: k8s.String(ip)}
+	}
+
+	tests := []struct {
+		name      string
+		hostnames []string
+		node      *corev1.Node
+		want      []DNSEndpointRecord
+	}{
+		{
+			name:      "without external ip addresses",
+			hostnames: []string{"node-1.nodes.example.com"},
+			node:      node(address("InternalIP", "10.0.0.1"), address("Hostname", "node-1")),
+			want:      nil,
+		},
+		{
+			name:      "sorted ipv4 addresses",
+			hostnames: []string{"node-1.nodes.example.com"},
+			node:      node(address("InternalIP", "10.0.0.1"), address("ExternalIP", "35.0.0.2"), address("ExternalIP", "35.0.0.1")),
+			want: []DNSEndpointRecord{
+				{Name: "node-1.nodes.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"35.0.0.1", "35.0.0.2"}},
+			},
+		},
+		{
+			name:      "ipv4 and ipv6 addresses for each hostname",
+			hostnames: []string{"a.example.com", "b.example.com"},
+			node:      node(address("ExternalIP", "2001:db8::1"), address("ExternalIP", "35.0.0.1"), address("ExternalIP", "not-an-ip")),
+			want: []DNSEndpointRecord{
+				{Name: "a.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"35.0.0.1"}},
+				{Name: "a.example.com", Type: "AAAA", TTL: dnsRecordTTL, Targets: []string{"2001:db8::1"}},
+				{Name: "b.example.com", Type: "A", TTL: dnsRecordTTL, Targets: []string{"35.0.0.1"}},
+				{Name: "b.example.com", Type: "AAAA", TTL: dnsRecordTTL, Targets: []string{"2001:db8::1"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getNodeRecords(tt.hostnames, tt.node); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getNodeRecords() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	foundation "github.com/estafette/estafette-foundation"
+)
+
+// ownerRecordPrefix is prepended to a hostname to get the name of the txt record that marks which object owns its records; a
+// prefix is used so the txt record doesn't clash with a record type that can't share its name, like a cname
+const ownerRecordPrefix string = "_estafette-google-cloud-dns."
+
+// ownerRecordTypesField is the field of an owner record that lists the types of the records it marks, separated by semicolons
+const ownerRecordTypesField string = "types"
+
+// defaultOwnerID is the owner id of a controller instance that hasn't been given its own with --owner-id
+const defaultOwnerID string = "default"
+
+// ownerRecordName returns the name of the txt record marking the owner of the records of a hostname
+func ownerRecordName(hostname string) string {
+	return ownerRecordPrefix + hostname
+}
+
+// isOwnerRecordName returns true if a hostname is the name of an owner record, which objects can't set records for, since they'd
+// overwrite or forge the ownership of the records of another hostname
+func isOwnerRecordName(hostname string) bool {
+	return strings.HasPrefix(hostname, ownerRecordPrefix)
+}
+
+// ownerRecordValue returns the value of the txt record marking an object as the owner of the records of a hostname, in the form
+// heritage=estafette-google-cloud-dns,owner=<owner id>,resource=<kind>/<namespace>/<name>
+func ownerRecordValue(kind string, metadata *metav1.ObjectMeta) string {
+	return fmt.Sprintf("heritage=estafette-google-cloud-dns,owner=%v,resource=%v/%v/%v", *ownerID, strings.ToLower(kind), metadata.GetNamespace(), metadata.GetName())
+}
+
+// withOwnerRecordTypes returns the value of an owner record marking the records of the given types of a hostname, in the form
+// <owner record value>,types=<type>;<type>, so garbage collection only deletes those and leaves other records at the same name alone
+func withOwnerRecordTypes(owner string, types []string) string {
+	return fmt.Sprintf("%v,%v=%v", withoutOwnerRecordTypes(owner), ownerRecordTypesField, strings.Join(types, ";"))
+}
+
+// withoutOwnerRecordTypes returns the value of an owner record without the types of the records it marks, which identifies the object
+// owning them
+func withoutOwnerRecordTypes(value string) string {
+	fields := []string{}
+	for _, field := range strings.Split(value, ",") {
+		if !strings.HasPrefix(field, ownerRecordTypesField+"=") {
+			fields = append(fields, field)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// getOwnerRecordTypes returns the types of the records an owner record marks; owner records written before the types were added
+// only marked A records
+func getOwnerRecordTypes(value string) (types []string) {
+	for _, field := range strings.Split(value, ",") {
+		if !strings.HasPrefix(field, ownerRecordTypesField+"=") {
+			continue
+		}
+		for _, recordType := range strings.Split(strings.TrimPrefix(field, ownerRecordTypesField+"="), ";") {
+			if recordType != "" && !foundation.StringArrayContains(types, recordType) {
+				types = append(types, recordType)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return []string{"A"}
+	}
+	return types
+}
+
+// addOwnerRecordType returns the value of the owner record of a hostname after setting a record of a type for an object; the types
+// marked by the current owner record are kept if it marks records of the same object
+func addOwnerRecordType(currentOwner, owner, recordType string) string {
+	types := []string{}
+	if currentOwner != "" && !isOwnershipConflict(currentOwner, owner) {
+		types = getOwnerRecordTypes(currentOwner)
+	}
+	if !foundation.StringArrayContains(types, recordType) {
+		types = append(types, recordType)
+	}
+	sort.Strings(types)
+
+	return withOwnerRecordTypes(owner, types)
+}
+
+// backfillOwnerRecordType returns the value of the owner record of a hostname after marking a record an older version of the
+// controller set for an object; ok is false if another object or controller instance has taken the records over in the meantime
+func backfillOwnerRecordType(currentOwner, owner, recordType string) (value string, ok bool) {
+	if isOwnershipConflict(currentOwner, owner) {
+		return currentOwner, false
+	}
+	return addOwnerRecordType(currentOwner, owner, recordType), true
+}
+
+// removeOwnerRecordTypes returns the types of the records an owner record marks that are left after deleting the records of the given types
+func removeOwnerRecordTypes(currentOwner string, recordTypes []string) (types []string) {
+	for _, recordType := range getOwnerRecordTypes(currentOwner) {
+		if !foundation.StringArrayContains(recordTypes, recordType) {
+			types = append(types, recordType)
+		}
+	}
+	return
+}
+
+// isOwnershipConflict returns true if the records of a hostname are owned by another object or another controller instance;
+// records without an owner record, like the ones set by older versions of the controller, are taken over
+func isOwnershipConflict(currentOwner, owner string) bool {
+	return currentOwner != "" && withoutOwnerRecordTypes(currentOwner) != withoutOwnerRecordTypes(owner)
+}
+
+// parseOwnerRecordValue returns the owner id and the kind, namespace and name of the object from the value of an owner record; ok is
+// false if the record wasn't written by this controller
+func parseOwnerRecordValue(value string) (owner, kind, namespace, name string, ok bool) {
+	heritage := ""
+	resource := ""
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "heritage":
+			heritage = parts[1]
+		case "owner":
+			owner = parts[1]
+		case "resource":
+			resource = parts[1]
+		}
+	}
+
+	resourceParts := strings.Split(resource, "/")
+	if heritage != "estafette-google-cloud-dns" || len(resourceParts) != 3 {
+		return "", "", "", "", false
+	}
+
+	return owner, resourceParts[0], resourceParts[1], resourceParts[2], true
+}
+
+// isOwnedByThisController returns true if the owner record was written for any object by this controller instance
+func isOwnedByThisController(currentOwner string) bool {
+	owner, _, _, _, ok := parseOwnerRecordValue(currentOwner)
+	return ok && owner == *ownerID
+}
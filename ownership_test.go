@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestGetOwnerRecordTypes(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a", []string{"A"}},
+		{"heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a,types=A", []string{"A"}},
+		{"heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a,types=A;AAAA", []string{"A", "AAAA"}},
+		{"heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a,types=", []string{"A"}},
+		{"heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a,types=CNAME;CNAME", []string{"CNAME"}},
+	}
+
+	for _, tt := range tests {
+		if got := getOwnerRecordTypes(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getOwnerRecordTypes(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAddOwnerRecordType(t *testing.T) {
+
+	owner := "heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a"
+	otherOwner := "heritage=estafette-google-cloud-dns,owner=default,resource=service/default/b"
+
+	tests := []struct {
+		name         string
+		currentOwner string
+		recordType   string
+		want         string
+	}{
+		{"no owner record", "", "A", owner + ",types=A"},
+		{"owner record without types", owner, "AAAA", owner + ",types=A;AAAA"},
+		{"same type again", owner + ",types=A", "A", owner + ",types=A"},
+		{"another type of the same object", owner + ",types=AAAA", "A", owner + ",types=A;AAAA"},
+		{"taken over from another object", otherOwner + ",types=A;AAAA", "CNAME", owner + ",types=CNAME"},
+	}
+
+	for _, tt := range tests {
+		if got := addOwnerRecordType(tt.currentOwner, owner, tt.recordType); got != tt.want {
+			t.Errorf("%v: addOwnerRecordType() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveOwnerRecordTypes(t *testing.T) {
+
+	owner := "heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a"
+
+	tests := []struct {
+		currentOwner string
+		recordTypes  []string
+		want         []string
+	}{
+		{owner, []string{"A"}, nil},
+		{owner + ",types=A;AAAA", []string{"A"}, []string{"AAAA"}},
+		{owner + ",types=A;AAAA", []string{"A", "AAAA"}, nil},
+		{owner + ",types=CNAME", []string{"A"}, []string{"CNAME"}},
+	}
+
+	for _, tt := range tests {
+		if got := removeOwnerRecordTypes(tt.currentOwner, tt.recordTypes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("removeOwnerRecordTypes(%q, %v) = %v, want %v", tt.currentOwner, tt.recordTypes, got, tt.want)
+		}
+	}
+}
+
+func TestIsOwnershipConflict(t *testing.T) {
+
+	owner := "heritage=estafette-google-cloud-dns,owner=default,resource=service/default/a"
+
+	tests := []struct {
+		name         string
+		currentOwner string
+		want         bool
+	}{
+		{"no owner record", "", false},
+		{"same object", owner, false},
+		{"same object with types", owner + ",types=A;AAAA", false},
+		{"other object", "heritage=estafette-google-cloud-dns,owner=default,resource=service/default/b,types=A", true},
+		{"other controller instance", "heritage=estafette-google-cloud-dns,owner=other,resource=service/default/a,types=A", true},
+	}
+
+	for _, tt := range tests {
+		if got := isOwnershipConflict(tt.currentOwner, owner); got != tt.want {
+			t.Errorf("%v: isOwnershipConflict() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackfillOwnerRecordType(t *testing.T) {
+
+	defaultOwnerIDValue := *ownerID
+	defer func() { *ownerID = defaultOwnerIDValue }()
+	*ownerID = "cluster-a"
+
+	metadata := &metav1.ObjectMeta{Name: proto.String("shop"), Namespace: proto.String("default")}
+	owner := ownerRecordValue("Service", metadata)
+
+	// a record set by a version of the controller that didn't write owner records
+	state := migrateState(GoogleCloudDNSState{Enabled: "true", Hostnames: "shop.example.com", IPAddress: "10.0.0.1"})
+	if !hasUnownedRecords(state) {
+		t.Fatalf("hasUnownedRecords() = false for migrated records %+v, want true", state.Records)
+	}
+	record := state.Records[0]
+
+	value, ok := backfillOwnerRecordType("", owner, record.Type)
+	if !ok {
+		t.Fatalf("backfillOwnerRecordType() ok = false without an owner record, want true")
+	}
+
+	// garbage collection deletes the records of the types an owner record of this controller instance marks, once its object is gone
+	parsedOwner, kind, namespace, name, ok := parseOwnerRecordValue(value)
+	if !ok || parsedOwner != *ownerID || kind != "service" || namespace != "default" || name != "shop" {
+		t.Errorf("parseOwnerRecordValue(%q) = %v, %v, %v, %v, %v, want cluster-a, service, default, shop, true", value, parsedOwner, kind, namespace, name, ok)
+	}
+	if got := getOwnerRecordTypes(value); !reflect.DeepEqual(got, []string{record.Type}) {
+		t.Errorf("getOwnerRecordTypes(%q) = %v, want %v", value, got, []string{record.Type})
+	}
+
+	record.Status = recordStatusOwned
+	state.Records = []GoogleCloudDNSRecordState{record}
+	if hasUnownedRecords(state) || !isSyncedRecordStatus(record.Status) {
+		t.Errorf("record with status %v is still unowned or no longer synced", record.Status)
+	}
+
+	tests := []struct {
+		name         string
+		currentOwner string
+		want         string
+		wantOK       bool
+	}{
+		{"owner record of the same object", owner + ",types=AAAA", owner + ",types=A;AAAA", true},
+		{"owner record of another object", "heritage=estafette-google-cloud-dns,owner=cluster-a,resource=service/default/cart,types=A", "heritage=estafette-google-cloud-dns,owner=cluster-a,resource=service/default/cart,types=A", false},
+		{"owner record of another controller instance", "heritage=estafette-google-cloud-dns,owner=cluster-b,resource=service/default/shop,types=A", "heritage=estafette-google-cloud-dns,owner=cluster-b,resource=service/default/shop,types=A", false},
+	}
+
+	for _, tt := range tests {
+		if got, ok := backfillOwnerRecordType(tt.currentOwner, owner, "A"); got != tt.want || ok != tt.wantOK {
+			t.Errorf("%v: backfillOwnerRecordType() = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
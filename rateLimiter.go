@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all callers, used to stay within the request quota of the Cloud DNS api
+type rateLimiter struct {
+	tokens chan struct{}
+}
+
+// newRateLimiter returns a rate limiter allowing requestsPerSecond on average with bursts of up to burst requests; a rate of
+// zero or less disables rate limiting
+func newRateLimiter(requestsPerSecond float64, burst int) *rateLimiter {
+	if requestsPerSecond <= 0 {
+		return &rateLimiter{}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	limiter := &rateLimiter{
+		tokens: make(chan struct{}, burst),
+	}
+	for i := 0; i < burst; i++ {
+		limiter.tokens <- struct{}{}
+	}
+
+	// refill the bucket at the configured rate
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / requestsPerSecond))
+		for range ticker.C {
+			select {
+			case limiter.tokens <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return limiter
+}
+
+// Wait blocks until a request is allowed
+func (limiter *rateLimiter) Wait() {
+	if limiter == nil || limiter.tokens == nil {
+		return
+	}
+	<-limiter.tokens
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// annotationGoogleCloudDNSRequireReadyEndpoints set to true only publishes the records of a service while it has ready endpoints
+const annotationGoogleCloudDNSRequireReadyEndpoints string = "estafette.io/google-cloud-dns-require-ready-endpoints"
+
+// annotationGoogleCloudDNSReadyHoldDown overrides the --ready-hold-down duration the records are kept after the last endpoint is gone
+const annotationGoogleCloudDNSReadyHoldDown string = "estafette.io/google-cloud-dns-ready-hold-down"
+
+// annotationGoogleCloudDNSFallbackTarget holds the ip addresses or dns name the records point to once the hold-down has passed; the
+// records are withdrawn if it isn't set
+const annotationGoogleCloudDNSFallbackTarget string = "estafette.io/google-cloud-dns-fallback-target"
+
+// scheduleRequeue queues a work item again after a delay, to process a service once its hold-down has passed; it's set once the work
+// queue exists
+var scheduleRequeue = func(item workItem, delay time.Duration) {}
+
+// requiresReadyEndpoints returns true if the records of a service are only published while it has ready endpoints; services of type
+// ExternalName don't have endpoints and headless services already follow their endpoints
+func requiresReadyEndpoints(service *corev1.Service) bool {
+	return *enableReadyEndpoints && service.Metadata.Annotations[annotationGoogleCloudDNSRequireReadyEndpoints] == "true" &&
+		service.GetSpec().GetType() != "ExternalName" && !isHeadlessService(service)
+}
+
+// hasReadyEndpoints returns true if any of the endpoint slices of a service has a ready endpoint
+func hasReadyEndpoints(client *k8s.Client, service *corev1.Service) (bool, error) {
+
+	slices, err := getServiceEndpointSlices(client, service)
+	if err != nil {
+		return false, err
+	}
+
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if isEndpointReady(endpoint) && len(endpoint.Addresses) > 0 {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// getReadyHoldDown returns the hold-down of a service from its annotation, or the --ready-hold-down flag if it isn't set or invalid
+func getReadyHoldDown(service *corev1.Service) time.Duration {
+
+	value, ok := service.Metadata.Annotations[annotationGoogleCloudDNSReadyHoldDown]
+	if !ok {
+		return *readyHoldDown
+	}
+
+	holdDown, err := time.ParseDuration(value)
+	if err != nil || holdDown < 0 {
+		log.Warn().Err(err).Msgf("Parsing hold-down %v for %v.%v failed, using %v instead", value, service.Metadata.GetName(), service.Metadata.GetNamespace(), *readyHoldDown)
+		return *readyHoldDown
+	}
+
+	return holdDown
+}
+
+// applyReadyEndpoints adjusts the desired state of a service that requires ready endpoints while it has none; during the hold-down its
+// records are kept as they are, after that they're switched to the fallback target or, without one, withdraw is true
+func applyReadyEndpoints(client *k8s.Client, service *corev1.Service, desiredState, currentState GoogleCloudDNSState) (state GoogleCloudDNSState, withdraw bool, err error) {
+
+	ready, err := hasReadyEndpoints(client, service)
+	if err != nil {
+		return desiredState, false, err
+	}
+	if ready {
+		desiredState.NotReadySince = ""
+		return desiredState, false, nil
+	}
+
+	notReadySince := time.Now().UTC()
+	if since, err := time.Parse(time.RFC3339, currentState.NotReadySince); err == nil {
+		notReadySince = since
+	}
+	desiredState.NotReadySince = notReadySince.Format(time.RFC3339)
+
+	// keep the records that are published until the hold-down has passed, so a rolling restart doesn't withdraw them
+	remaining := time.Until(notReadySince.Add(getReadyHoldDown(service)))
+	if remaining > 0 && len(getSyncedHostnames(currentState)) > 0 {
+		desiredState.IPAddress = currentState.IPAddress
+		desiredState.RecordType = currentState.RecordType
+		desiredState.Target = currentState.Target
+
+		scheduleRequeue(workItem{
+			Namespace: service.Metadata.GetNamespace(),
+			Kind:      serviceKind.kind,
+			Name:      service.Metadata.GetName(),
+			Initiator: "hold-down",
+		}, remaining+time.Second)
+
+		return desiredState, false, nil
+	}
+
+	fallbackTarget, ok := service.Metadata.Annotations[annotationGoogleCloudDNSFallbackTarget]
+	if !ok || strings.TrimSpace(fallbackTarget) == "" {
+		return desiredState, true, nil
+	}
+
+	desiredState.IPAddress, desiredState.RecordType, desiredState.Target, err = parseFallbackTarget(fallbackTarget)
+
+	return desiredState, false, err
+}
+
+// parseFallbackTarget returns comma-separated ipv4 addresses as the ip address of A records, or a dns name as the target of a CNAME
+// record
+func parseFallbackTarget(value string) (ipAddress, recordType, target string, err error) {
+
+	ipAddresses := []string{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if isIPv4Address(part) {
+			ipAddresses = appendUnique(ipAddresses, part)
+		} else if len(ipAddresses) > 0 || strings.Contains(value, ",") {
+			return "", "", "", newInvalidAddressSourceError("fallback target %v should be either ipv4 addresses or a single dns name", value)
+		}
+	}
+	if len(ipAddresses) > 0 {
+		sort.Strings(ipAddresses)
+		return strings.Join(ipAddresses, ","), "", "", nil
+	}
+
+	dnsName := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(value), "."))
+	if !validateHostname(dnsName) {
+		return "", "", "", newInvalidAddressSourceError("fallback target %v is neither ipv4 addresses nor a valid dns name", value)
+	}
+
+	return "", "CNAME", dnsName + ".", nil
+}
+
+// withdrawServiceRecords deletes the records of a service that has been without ready endpoints for longer than its hold-down and
+// doesn't have a fallback target; they're published again as soon as an endpoint is ready
+func withdrawServiceRecords(dnsService *GoogleCloudDNSService, client *k8s.Client, service *corev1.Service, initiator string, desiredState, currentState GoogleCloudDNSState) (status string, err error) {
+
+	if len(getSyncedHostnames(currentState)) > 0 {
+		log.Info().Msgf("[%v] Service %v.%v - Withdrawing dns records, the service has no ready endpoints since %v...", initiator, *service.Metadata.Name, *service.Metadata.Namespace, desiredState.NotReadySince)
+	}
+
+	currentState.IPAddress = ""
+	currentState.RecordType = ""
+	currentState.Target = ""
+	currentState.NotReadySince = desiredState.NotReadySince
+
+	return makeRecordSetChanges(dnsService, client, "Service", service, initiator, nil, currentState, true)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFallbackTarget(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		value          string
+		wantIPAddress  string
+		wantRecordType string
+		wantTarget     string
+		wantErr        bool
+	}{
+		{
+			name:          "single ip address",
+			value:         "10.0.0.1",
+			wantIPAddress: "10.0.0.1",
+		},
+		{
+			name:          "ip addresses get sorted and deduplicated",
+			value:         "10.0.0.2, 10.0.0.1,10.0.0.2",
+			wantIPAddress: "10.0.0.1,10.0.0.2",
+		},
+		{
+			name:           "dns name becomes a fully qualified cname target",
+			value:          " Maintenance.Example.com. ",
+			wantRecordType: "CNAME",
+			wantTarget:     "maintenance.example.com.",
+		},
+		{
+			name:    "ip address mixed with a dns name",
+			value:   "10.0.0.1,maintenance.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "dns name mixed with an ip address",
+			value:   "maintenance.example.com,10.0.0.1",
+			wantErr: true,
+		},
+		{
+			name:    "multiple dns names",
+			value:   "a.example.com,b.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 address",
+			value:   "2001:db8::1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid dns name",
+			value:   "not a hostname",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			value:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipAddress, recordType, target, err := parseFallbackTarget(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFallbackTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := err.(*invalidAddressSourceError); !ok {
+					t.Errorf("parseFallbackTarget() error = %T, want *invalidAddressSourceError", err)
+				}
+				return
+			}
+			if ipAddress != tt.wantIPAddress || recordType != tt.wantRecordType || target != tt.wantTarget {
+				t.Errorf("parseFallbackTarget() = (%q, %q, %q), want (%q, %q, %q)", ipAddress, recordType, target, tt.wantIPAddress, tt.wantRecordType, tt.wantTarget)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// makeRecordSetChanges sets records that each have their own name, type and targets, like the records of a dns endpoint, and deletes
+// the ones that are no longer desired, with the same checks as the records of services and ingresses; the outcome is stored in the
+// state of the object, unless nothing changed and statusUpToDate tells the stored state doesn't need to be written either
+func makeRecordSetChanges(dnsService *GoogleCloudDNSService, client *k8s.Client, kind string, resource k8s.Resource, initiator string, desired []DNSEndpointRecord, currentState GoogleCloudDNSState, statusUpToDate bool) (status string, err error) {
+
+	status = "failed"
+
+	metadata := resource.GetMetadata()
+	owner := ownerRecordValue(kind, metadata)
+	claim := newHostnameClaim(strings.ToLower(kind), metadata)
+
+	invalidReason := eventReasonInvalidHostname
+	if kind == "DNSEndpoint" {
+		invalidReason = eventReasonInvalidEndpoint
+	}
+
+	// validate the records up front, so the ones that are removed from the spec are known
+	desiredRecords := []DNSEndpointRecord{}
+	recordErrors := map[int]error{}
+	desiredKeys := map[string]bool{}
+	allowedHostnames := []string{}
+	for i, record := range desired {
+		record, err := normalizeDNSEndpointRecord(record)
+		if err == nil && !validateHostname(record.Name) {
+			err = fmt.Errorf("hostname %v is invalid", record.Name)
+		}
+		if err != nil {
+			recordErrors[i] = err
+		} else {
+			desiredKeys[dnsEndpointRecordKey(record.Name, record.Type)] = true
+			if allowed, err := isHostnameAllowed(client, *metadata.Namespace, record.Name); err == nil && allowed {
+				allowedHostnames = append(allowedHostnames, record.Name)
+			}
+		}
+		desiredRecords = append(desiredRecords, record)
+	}
+	lostHostnames := hostnameClaims.Claim(claim, allowedHostnames)
+
+	records := []GoogleCloudDNSRecordState{}
+	changeID := currentState.ChangeID
+
+	previousRecords := map[string]GoogleCloudDNSRecordState{}
+	for _, record := range currentState.Records {
+		previousRecords[dnsEndpointRecordKey(record.Hostname, record.Type)] = record
+	}
+
+	// failed stores the error in the state, along with the records that are known to be set
+	failed := func(failedRecords []GoogleCloudDNSRecordState, err error) (string, error) {
+		failedState := currentState
+		failedState.Records = failedRecords
+		failedState.LastError = err.Error()
+		failedState.ChangeID = changeID
+
+		// the status is only stored when the error changes, so storing it doesn't keep triggering watch events
+		if failedState.LastError != currentState.LastError {
+			if stateErr := updateState(client, kind, resource, initiator, failedState); stateErr != nil {
+				log.Warn().Err(stateErr).Msgf("[%v] %v %v.%v - Storing the error in the state failed", initiator, kind, *metadata.Name, *metadata.Namespace)
+			}
+		}
+
+		return status, err
+	}
+
+	// delete the records that have been removed from the spec; the owner record of their hostname keeps marking the other types
+	for _, record := range currentState.Records {
+		if desiredKeys[dnsEndpointRecordKey(record.Hostname, record.Type)] || !isSyncedRecordStatus(record.Status) {
+			continue
+		}
+
+		log.Info().Msgf("[%v] %v %v.%v - Deleting dns record %v (%v), it has been removed...", initiator, kind, *metadata.Name, *metadata.Namespace, record.Hostname, record.Type)
+
+		deleted, recordChangeID, err := dnsService.DeleteDNSRecord(record.Type, record.Hostname, owner)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) failed", initiator, kind, *metadata.Name, *metadata.Namespace, record.Hostname, record.Type)
+			recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Deleting dns record %v (%v) failed: %v", record.Hostname, record.Type, err)
+			// all records are kept in the status, so deleting is retried
+			return failed(currentState.Records, err)
+		}
+		if deleted {
+			changeID = recordChangeID
+			recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordDeleted, "Deleted dns record %v (%v)", record.Hostname, record.Type)
+		}
+	}
+
+	// loop all records
+	for i, record := range desiredRecords {
+
+		previousRecord, hasPreviousRecord := previousRecords[dnsEndpointRecordKey(record.Name, record.Type)]
+		wasInConflict := hasPreviousRecord && previousRecord.Status == recordStatusConflict
+
+		// skip invalid records
+		if err, ok := recordErrors[i]; ok {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Invalid dns record %v (%v), skipping", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, record.Type)
+			if !(hasPreviousRecord && previousRecord.Status == recordStatusInvalid) {
+				recordEvent(client, kind, resource, eventTypeWarning, invalidReason, "Skipped invalid dns record %v (%v): %v", record.Name, record.Type, err)
+			}
+			records = append(records, newDNSEndpointRecordState(record, recordStatusInvalid))
+			continue
+		}
+
+		// skip hostnames outside of the zone, they can't be set
+		zoneDNSName, err := dnsService.GetZoneDNSName()
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Retrieving the dns name of zone %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, *googleCloudDNSZone)
+			recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Retrieving the dns name of zone %v failed: %v", *googleCloudDNSZone, err)
+			return failed(append(records, newDNSEndpointRecordState(record, recordStatusFailed)), err)
+		}
+		if !isHostnameInZone(record.Name, zoneDNSName) {
+			log.Error().Msgf("[%v] %v %v.%v - Dns record %v isn't within zone %v, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, zoneDNSName)
+			if !(hasPreviousRecord && previousRecord.Status == recordStatusInvalid) {
+				recordEvent(client, kind, resource, eventTypeWarning, eventReasonInvalidHostname, "Skipped hostname %v, it isn't within zone %v", record.Name, zoneDNSName)
+			}
+			records = append(records, newDNSEndpointRecordState(record, recordStatusInvalid))
+			continue
+		}
+
+		// skip ns and cname records at the zone apex; the ns records there delegate the zone itself, so replacing them would hand the
+		// whole zone to whoever can create a dns endpoint, and a cname can't coexist with them
+		if (record.Type == "NS" || record.Type == "CNAME") && isZoneApex(record.Name, zoneDNSName) {
+			log.Error().Msgf("[%v] %v %v.%v - Dns record %v (%v) is at the apex of zone %v, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, record.Type, zoneDNSName)
+			if !(hasPreviousRecord && previousRecord.Status == recordStatusInvalid) {
+				recordEvent(client, kind, resource, eventTypeWarning, invalidReason, "Skipped dns record %v (%v), %v records can't be set at the apex of zone %v", record.Name, record.Type, record.Type, zoneDNSName)
+			}
+			records = append(records, newDNSEndpointRecordState(record, recordStatusInvalid))
+			continue
+		}
+
+		// skip hostnames the domain policy doesn't allow the namespace to claim
+		allowed, err := isHostnameAllowed(client, *metadata.Namespace, record.Name)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Checking the domain policy for hostname %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name)
+			recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Checking the domain policy for hostname %v failed: %v", record.Name, err)
+			return failed(append(records, newDNSEndpointRecordState(record, recordStatusFailed)), err)
+		}
+		if !allowed {
+			log.Warn().Msgf("[%v] %v %v.%v - Hostname %v isn't allowed for namespace %v by the domain policy, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, *metadata.Namespace)
+			if !(hasPreviousRecord && previousRecord.Status == recordStatusDenied) {
+				recordEvent(client, kind, resource, eventTypeWarning, eventReasonPolicyViolation, "Rejected hostname %v, the domain policy doesn't allow namespace %v to claim it", record.Name, *metadata.Namespace)
+				policyViolationTotals.With(prometheus.Labels{"namespace": *metadata.Namespace, "type": claim.item.Kind}).Inc()
+			}
+
+			// a record set before the policy denied the hostname is deleted; if that fails it's kept in the state, so it's retried
+			if hasPreviousRecord && isSyncedRecordStatus(previousRecord.Status) {
+				log.Info().Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) denied by the domain policy...", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, record.Type)
+
+				deleted, recordChangeID, err := dnsService.DeleteDNSRecord(record.Type, record.Name, owner)
+				if err != nil {
+					log.Error().Err(err).Msgf("[%v] %v %v.%v - Deleting dns record %v (%v) failed", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, record.Type)
+					recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Deleting dns record %v (%v) denied by the domain policy failed: %v", record.Name, record.Type, err)
+					return failed(append(records, previousRecord), err)
+				}
+				if deleted {
+					changeID = recordChangeID
+					recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordDeleted, "Deleted dns record %v (%v), the domain policy doesn't allow namespace %v to claim it", record.Name, record.Type, *metadata.Namespace)
+				}
+			}
+
+			records = append(records, newDNSEndpointRecordState(record, recordStatusDenied))
+			continue
+		}
+
+		// skip hostnames claimed by another object that takes precedence
+		if winner, ok := lostHostnames[record.Name]; ok {
+			log.Warn().Msgf("[%v] %v %v.%v - Hostname %v is claimed by %v %v.%v as well, which takes precedence, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, winner.item.Kind, winner.item.Name, winner.item.Namespace)
+			if !wasInConflict {
+				recordEvent(client, kind, resource, eventTypeWarning, eventReasonHostnameConflict, "Skipped hostname %v, it's claimed by %v %v.%v as well, which takes precedence", record.Name, winner.item.Kind, winner.item.Name, winner.item.Namespace)
+				hostnameConflictTotals.With(prometheus.Labels{"namespace": *metadata.Namespace, "type": claim.item.Kind}).Inc()
+			}
+			records = append(records, newDNSEndpointRecordState(record, recordStatusConflict))
+			continue
+		}
+
+		// keep records that are already set to the targets
+		if hasPreviousRecord && isSyncedRecordStatus(previousRecord.Status) &&
+			previousRecord.TTL == record.TTL && reflect.DeepEqual(previousRecord.Targets, record.Targets) {
+			records = append(records, previousRecord)
+			continue
+		}
+
+		// skip hostnames whose records are owned by another controller instance
+		currentOwner, err := dnsService.GetDNSRecordOwner(record.Name)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Retrieving owner of dns record %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name)
+			recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Retrieving owner of dns record %v failed: %v", record.Name, err)
+			return failed(append(records, newDNSEndpointRecordState(record, recordStatusFailed)), err)
+		}
+		if isOwnershipConflict(currentOwner, owner) && !isOwnedByThisController(currentOwner) {
+			log.Warn().Msgf("[%v] %v %v.%v - Dns record %v is owned by %v, skipping", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, currentOwner)
+			if !wasInConflict {
+				recordEvent(client, kind, resource, eventTypeWarning, eventReasonOwnershipConflict, "Skipped hostname %v, its dns record is owned by %v", record.Name, currentOwner)
+			}
+			records = append(records, newDNSEndpointRecordState(record, recordStatusConflict))
+			continue
+		}
+
+		targets := strings.Join(record.Targets, ", ")
+		log.Info().Msgf("[%v] %v %v.%v - Upserting dns record %v (%v) to %v...", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, record.Type, targets)
+
+		created, recordChangeID, err := dnsService.UpsertDNSRecordSet(record.Type, record.Name, record.TTL, record.Targets, owner)
+		if err != nil {
+			log.Error().Err(err).Msgf("[%v] %v %v.%v - Upserting dns record %v (%v) to %v failed", initiator, kind, *metadata.Name, *metadata.Namespace, record.Name, record.Type, targets)
+			recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Upserting dns record %v (%v) to %v failed: %v", record.Name, record.Type, targets, err)
+			return failed(append(records, newDNSEndpointRecordState(record, recordStatusFailed)), err)
+		}
+		changeID = recordChangeID
+
+		recordState := newDNSEndpointRecordState(record, recordStatusUpdated)
+		recordState.ChangeID = recordChangeID
+		if created {
+			recordState.Status = recordStatusCreated
+			recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordCreated, "Created dns record %v (%v) with %v", record.Name, record.Type, targets)
+		} else {
+			recordEvent(client, kind, resource, eventTypeNormal, eventReasonRecordUpdated, "Updated dns record %v (%v) to %v", record.Name, record.Type, targets)
+		}
+		records = append(records, recordState)
+	}
+
+	// nothing changed if the records are the same and the stored state is up to date
+	if currentState.LastError == "" && sameRecordStates(records, currentState.Records) && hasFinalizer(metadata) && statusUpToDate {
+		return "skipped", nil
+	}
+
+	hostnames := []string{}
+	for _, record := range records {
+		hostnames = append(hostnames, record.Hostname)
+	}
+
+	currentState.SchemaVersion = stateSchemaVersion
+	currentState.Enabled = "true"
+	currentState.Hostnames = joinHostnames(hostnames)
+	currentState.Records = records
+	currentState.LastSyncTime = time.Now().UTC().Format(time.RFC3339)
+	currentState.LastError = ""
+	currentState.ChangeID = changeID
+
+	log.Info().Msgf("[%v] %v %v.%v - Updating %v because records have changed...", initiator, kind, *metadata.Name, *metadata.Namespace, strings.ToLower(kind))
+
+	// make sure the records get deleted along with the object
+	addFinalizer(metadata)
+
+	err = updateState(client, kind, resource, initiator, currentState)
+	if err != nil {
+		recordEvent(client, kind, resource, eventTypeWarning, eventReasonAPIError, "Storing the dns state failed: %v", err)
+		return status, err
+	}
+
+	return "succeeded", nil
+}
+
+// sameRecordStates returns true if both lists hold the same record states, where no records and an empty list are the same
+func sameRecordStates(a, b []GoogleCloudDNSRecordState) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
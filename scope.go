@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/golang/protobuf/proto"
+)
+
+const annotationIngressClass string = "kubernetes.io/ingress.class"
+
+// serviceSpecLoadBalancerClassField is the protobuf field number of spec.loadBalancerClass, which is newer than the service type
+// of the kubernetes client and thus only available in its unrecognized fields
+const serviceSpecLoadBalancerClassField uint64 = 21
+
+// watchNamespaces returns the namespaces to list and watch objects in, either the ones set with --namespace or all namespaces
+func watchNamespaces() []string {
+	if len(*namespaces) == 0 {
+		return []string{k8s.AllNamespaces}
+	}
+	return *namespaces
+}
+
+// namespaceDescription returns a namespace as used in log messages
+func namespaceDescription(namespace string) string {
+	if namespace == k8s.AllNamespaces {
+		return "all namespaces"
+	}
+	return fmt.Sprintf("namespace %v", namespace)
+}
+
+// listOptions returns the options to pass to list and watch calls to filter out objects by namespace exclusion and label selector
+func listOptions() (options []k8s.Option) {
+	options = namespaceListOptions()
+	if *labelSelector != "" {
+		options = append(options, k8s.QueryParam("labelSelector", *labelSelector))
+	}
+	return
+}
+
+// namespaceListOptions returns the options to pass to list and watch calls to filter out objects by namespace exclusion only
+func namespaceListOptions() (options []k8s.Option) {
+	if len(*excludeNamespaces) > 0 {
+		fieldSelectors := []string{}
+		for _, namespace := range *excludeNamespaces {
+			fieldSelectors = append(fieldSelectors, fmt.Sprintf("metadata.namespace!=%v", namespace))
+		}
+		options = append(options, k8s.QueryParam("fieldSelector", strings.Join(fieldSelectors, ",")))
+	}
+	return
+}
+
+// isInScope returns true if the object passes the --namespace, --exclude-namespace and --label-selector filters; list and watch
+// calls already filter on those, this guards objects retrieved in other ways
+func isInScope(metadata *metav1.ObjectMeta) bool {
+	if metadata == nil {
+		return false
+	}
+	if len(*namespaces) > 0 && !foundation.StringArrayContains(*namespaces, metadata.GetNamespace()) {
+		return false
+	}
+	if foundation.StringArrayContains(*excludeNamespaces, metadata.GetNamespace()) {
+		return false
+	}
+	return labelSelectorMatches(*labelSelector, metadata.Labels)
+}
+
+// ingressClassMatches returns true if no --ingress-class filter is set or the class of the ingress, from spec.ingressClassName or
+// the legacy kubernetes.io/ingress.class annotation, is one of the filtered classes
+func ingressClassMatches(ingress *Ingress) bool {
+	if len(*ingressClasses) == 0 {
+		return true
+	}
+	if ingress.Spec.IngressClassName != nil && foundation.StringArrayContains(*ingressClasses, *ingress.Spec.IngressClassName) {
+		return true
+	}
+	if ingressClass, ok := ingress.Metadata.Annotations[annotationIngressClass]; ok && foundation.StringArrayContains(*ingressClasses, ingressClass) {
+		return true
+	}
+	return false
+}
+
+// loadBalancerClassMatches returns true if no --load-balancer-class filter is set or the spec.loadBalancerClass of the service is
+// one of the filtered classes
+func loadBalancerClassMatches(service *corev1.Service) bool {
+	if len(*loadBalancerClasses) == 0 {
+		return true
+	}
+	if service.Spec == nil {
+		return false
+	}
+	loadBalancerClass, ok := getUnrecognizedStringField(service.Spec.XXX_unrecognized, serviceSpecLoadBalancerClassField)
+
+	return ok && foundation.StringArrayContains(*loadBalancerClasses, loadBalancerClass)
+}
+
+// getUnrecognizedStringField decodes a string field from the unrecognized fields of a protobuf message
+func getUnrecognizedStringField(unrecognized []byte, fieldNumber uint64) (value string, ok bool) {
+	buffer := proto.NewBuffer(unrecognized)
+	for {
+		key, err := buffer.DecodeVarint()
+		if err != nil {
+			return
+		}
+
+		switch key & 0x7 {
+		case proto.WireVarint:
+			_, err = buffer.DecodeVarint()
+		case proto.WireFixed64:
+			_, err = buffer.DecodeFixed64()
+		case proto.WireFixed32:
+			_, err = buffer.DecodeFixed32()
+		case proto.WireBytes:
+			var bytes []byte
+			bytes, err = buffer.DecodeRawBytes(true)
+			if err == nil && key>>3 == fieldNumber {
+				return string(bytes), true
+			}
+		default:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// labelSelectorMatches evaluates a label selector as accepted by the kubernetes api against a set of labels, supporting the
+// key, !key, key=value, key==value, key!=value, key in (values) and key notin (values) requirements
+func labelSelectorMatches(selector string, labels map[string]string) bool {
+	for _, requirement := range splitLabelSelector(selector) {
+		requirement = strings.TrimSpace(requirement)
+		if requirement == "" {
+			continue
+		}
+
+		switch {
+		case strings.Contains(requirement, " notin "), strings.Contains(requirement, " in "):
+			operator := " in "
+			if strings.Contains(requirement, " notin ") {
+				operator = " notin "
+			}
+			parts := strings.SplitN(requirement, operator, 2)
+			key := strings.TrimSpace(parts[0])
+			values := strings.Split(strings.Trim(strings.TrimSpace(parts[1]), "()"), ",")
+			for i := range values {
+				values[i] = strings.TrimSpace(values[i])
+			}
+			value, ok := labels[key]
+			found := ok && foundation.StringArrayContains(values, value)
+			if found == (operator == " notin ") {
+				return false
+			}
+
+		case strings.Contains(requirement, "!="):
+			parts := strings.SplitN(requirement, "!=", 2)
+			if value, ok := labels[strings.TrimSpace(parts[0])]; ok && value == strings.TrimSpace(parts[1]) {
+				return false
+			}
+
+		case strings.Contains(requirement, "="):
+			parts := strings.SplitN(strings.Replace(requirement, "==", "=", 1), "=", 2)
+			if value, ok := labels[strings.TrimSpace(parts[0])]; !ok || value != strings.TrimSpace(parts[1]) {
+				return false
+			}
+
+		case strings.HasPrefix(requirement, "!"):
+			if _, ok := labels[strings.TrimSpace(requirement[1:])]; ok {
+				return false
+			}
+
+		default:
+			if _, ok := labels[requirement]; !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// splitLabelSelector splits a label selector into its requirements on the commas outside of parentheses
+func splitLabelSelector(selector string) (requirements []string) {
+	depth := 0
+	start := 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				requirements = append(requirements, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(requirements, selector[start:])
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestLabelSelectorMatches(t *testing.T) {
+
+	labels := map[string]string{
+		"app":  "shop",
+		"tier": "frontend",
+	}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"app", true},
+		{"team", false},
+		{"!team", true},
+		{"!app", false},
+		{"app=shop", true},
+		{"app==shop", true},
+		{"app=cart", false},
+		{"app!=cart", true},
+		{"app!=shop", false},
+		{"team!=a", true},
+		{"tier in (frontend, backend)", true},
+		{"tier in (backend)", false},
+		{"team in (a)", false},
+		{"tier notin (backend)", true},
+		{"tier notin (frontend,backend)", false},
+		{"team notin (a)", true},
+		{"app=shop,tier in (frontend,backend)", true},
+		{"app=shop, tier=backend", false},
+		{"app=shop,!team", true},
+	}
+
+	for _, tt := range tests {
+		if got := labelSelectorMatches(tt.selector, labels); got != tt.want {
+			t.Errorf("labelSelectorMatches(%q) = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestSplitLabelSelector(t *testing.T) {
+
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"", []string{""}},
+		{"app=shop", []string{"app=shop"}},
+		{"app=shop,tier!=backend", []string{"app=shop", "tier!=backend"}},
+		{"tier in (frontend,backend),app", []string{"tier in (frontend,backend)", "app"}},
+		{"a notin (x,y),b in (z)", []string{"a notin (x,y)", "b in (z)"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitLabelSelector(tt.selector); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLabelSelector(%q) = %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestGetUnrecognizedStringField(t *testing.T) {
+
+	// encode returns the protobuf encoding of a varint field 1, a fixed32 field 2 and the given string fields
+	encode := func(fields map[uint64]string) []byte {
+		buffer := proto.NewBuffer(nil)
+		buffer.EncodeVarint(1<<3 | proto.WireVarint)
+		buffer.EncodeVarint(150)
+		buffer.EncodeVarint(2<<3 | proto.WireFixed32)
+		buffer.EncodeFixed32(7)
+		for fieldNumber, value := range fields {
+			buffer.EncodeVarint(fieldNumber<<3 | proto.WireBytes)
+			buffer.EncodeStringBytes(value)
+		}
+		return buffer.Bytes()
+	}
+
+	tests := []struct {
+		name         string
+		unrecognized []byte
+		wantValue    string
+		wantOK       bool
+	}{
+		{"no fields", nil, "", false},
+		{"field present", encode(map[uint64]string{serviceSpecLoadBalancerClassField: "internal"}), "internal", true},
+		{"other string field", encode(map[uint64]string{20: "internal"}), "", false},
+		{"empty string", encode(map[uint64]string{serviceSpecLoadBalancerClassField: ""}), "", true},
+		{"truncated", encode(map[uint64]string{serviceSpecLoadBalancerClassField: "internal"})[:10], "", false},
+	}
+
+	for _, tt := range tests {
+		value, ok := getUnrecognizedStringField(tt.unrecognized, serviceSpecLoadBalancerClassField)
+		if value != tt.wantValue || ok != tt.wantOK {
+			t.Errorf("%v: getUnrecognizedStringField() = %q, %v, want %q, %v", tt.name, value, ok, tt.wantValue, tt.wantOK)
+		}
+	}
+}
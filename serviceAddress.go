@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+)
+
+// annotationGoogleCloudDNSAddressSource selects which address of a service its dns records point to
+const annotationGoogleCloudDNSAddressSource string = "estafette.io/google-cloud-dns-address-source"
+
+// address sources of a service
+const (
+	// addressSourceLoadBalancer uses the ip address in the load balancer status of a service of type LoadBalancer
+	addressSourceLoadBalancer string = "loadBalancer"
+	// addressSourceExternalIPs uses the ip addresses in the externalIPs of a service
+	addressSourceExternalIPs string = "externalIPs"
+	// addressSourceClusterIP uses the cluster ip of a service, for records in a private zone
+	addressSourceClusterIP string = "clusterIP"
+	// addressSourceNodeExternalIPs uses the external ip addresses of the ready nodes, for services of type NodePort or LoadBalancer
+	addressSourceNodeExternalIPs string = "nodeExternalIPs"
+)
+
+var addressSources = []string{addressSourceLoadBalancer, addressSourceExternalIPs, addressSourceClusterIP, addressSourceNodeExternalIPs}
+
+var (
+	// nodeAddresses holds the external ipv4 addresses each node contributes to the nodeExternalIPs address source, comma separated, as
+	// last seen by the node watcher
+	nodeAddresses      = map[string]string{}
+	nodeAddressesMutex = &sync.Mutex{}
+)
+
+// invalidAddressSourceError is returned when the address source of a service doesn't fit the service, so it can be told apart from
+// failing api calls
+type invalidAddressSourceError struct {
+	message string
+}
+
+func (e *invalidAddressSourceError) Error() string {
+	return e.message
+}
+
+func newInvalidAddressSourceError(format string, a ...interface{}) error {
+	return &invalidAddressSourceError{message: fmt.Sprintf(format, a...)}
+}
+
+// getServiceAddressSource returns the address source from the annotation of a service, the load balancer status if it isn't set
+func getServiceAddressSource(service *corev1.Service) (string, error) {
+
+	value, ok := service.Metadata.Annotations[annotationGoogleCloudDNSAddressSource]
+	if !ok || value == "" {
+		return addressSourceLoadBalancer, nil
+	}
+
+	for _, source := range addressSources {
+		if strings.EqualFold(value, source) {
+			return source, nil
+		}
+	}
+
+	return "", newInvalidAddressSourceError("address source %v is unknown, it should be one of %v", value, strings.Join(addressSources, ", "))
+}
+
+// getServiceIPAddresses returns the sorted ipv4 addresses the dns records of a service point to, according to its address source; it
+// returns none without an error while the load balancer of the service doesn't have an ip address yet
+func getServiceIPAddresses(client *k8s.Client, service *corev1.Service) (ipAddresses []string, err error) {
+
+	source, err := getServiceAddressSource(service)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceType := service.GetSpec().GetType()
+
+	switch source {
+	case addressSourceLoadBalancer:
+		if serviceType != "LoadBalancer" {
+			// without the annotation other types of services are skipped silently, like before the address source existed
+			if _, ok := service.Metadata.Annotations[annotationGoogleCloudDNSAddressSource]; !ok {
+				return nil, nil
+			}
+			return nil, newInvalidAddressSourceError("address source %v requires a service of type LoadBalancer, but it's of type %v", source, serviceType)
+		}
+		ingress := service.GetStatus().GetLoadBalancer().GetIngress()
+		if len(ingress) == 0 || ingress[0].GetIp() == "" {
+			return nil, nil
+		}
+		if !isIPv4Address(ingress[0].GetIp()) {
+			return nil, newInvalidAddressSourceError("load balancer ip address %v isn't an ipv4 address", ingress[0].GetIp())
+		}
+		return []string{ingress[0].GetIp()}, nil
+
+	case addressSourceExternalIPs:
+		externalIPs := service.GetSpec().GetExternalIPs()
+		if len(externalIPs) == 0 {
+			return nil, newInvalidAddressSourceError("address source %v requires the service to have externalIPs", source)
+		}
+		for _, ip := range externalIPs {
+			if !isIPv4Address(ip) {
+				return nil, newInvalidAddressSourceError("external ip %v isn't an ipv4 address", ip)
+			}
+			ipAddresses = appendUnique(ipAddresses, ip)
+		}
+
+	case addressSourceClusterIP:
+		clusterIP := service.GetSpec().GetClusterIP()
+		if clusterIP == "" || clusterIP == "None" {
+			return nil, newInvalidAddressSourceError("address source %v requires a service with a cluster ip, but it's headless", source)
+		}
+		if !isIPv4Address(clusterIP) {
+			return nil, newInvalidAddressSourceError("cluster ip %v isn't an ipv4 address", clusterIP)
+		}
+		return []string{clusterIP}, nil
+
+	case addressSourceNodeExternalIPs:
+		if !*enableNodeExternalIPs {
+			return nil, newInvalidAddressSourceError("address source %v is disabled, it requires the controller to be started with --enable-node-external-ips", source)
+		}
+		if serviceType != "NodePort" && serviceType != "LoadBalancer" {
+			return nil, newInvalidAddressSourceError("address source %v requires a service of type NodePort or LoadBalancer, but it's of type %v", source, serviceType)
+		}
+		ipAddresses, err = getNodeExternalIPAddresses(client)
+		if err != nil {
+			return nil, err
+		}
+		if len(ipAddresses) == 0 {
+			return nil, newInvalidAddressSourceError("address source %v requires nodes with an external ip address, but none of the ready nodes has one", source)
+		}
+	}
+
+	sort.Strings(ipAddresses)
+
+	return ipAddresses, nil
+}
+
+// getServiceExternalName returns the external name of an ExternalName service as the fully qualified target of a CNAME record
+func getServiceExternalName(service *corev1.Service) (string, error) {
+
+	if _, ok := service.Metadata.Annotations[annotationGoogleCloudDNSAddressSource]; ok {
+		return "", newInvalidAddressSourceError("an ExternalName service points to its external name, it can't have an address source")
+	}
+
+	externalName := strings.ToLower(strings.TrimSuffix(service.GetSpec().GetExternalName(), "."))
+	if !validateHostname(externalName) {
+		return "", newInvalidAddressSourceError("external name %v isn't a valid dns name", service.GetSpec().GetExternalName())
+	}
+
+	return externalName + ".", nil
+}
+
+// getNodeExternalIPAddresses returns the external ipv4 addresses of all ready nodes
+func getNodeExternalIPAddresses(client *k8s.Client) (ipAddresses []string, err error) {
+
+	var nodes corev1.NodeList
+	err = client.List(context.Background(), k8s.AllNamespaces, &nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes.Items {
+		for _, ip := range getReadyNodeExternalIPAddresses(node) {
+			ipAddresses = appendUnique(ipAddresses, ip)
+		}
+	}
+
+	return ipAddresses, nil
+}
+
+// getReadyNodeExternalIPAddresses returns the external ipv4 addresses a node contributes to the nodeExternalIPs address source, which are
+// none while it isn't ready
+func getReadyNodeExternalIPAddresses(node *corev1.Node) (ipAddresses []string) {
+	if !isNodeReady(node) {
+		return nil
+	}
+	for _, address := range node.GetStatus().GetAddresses() {
+		if address.GetType() == "ExternalIP" && isIPv4Address(address.GetAddress()) {
+			ipAddresses = appendUnique(ipAddresses, address.GetAddress())
+		}
+	}
+	return ipAddresses
+}
+
+// nodeAddressesChanged returns true if the addresses a node contributes to the nodeExternalIPs address source changed since the node
+// watcher last saw it, because it left, became ready or not ready or got other external ip addresses; nodes that are listed for the
+// first time aren't a change, since the services get processed when they're listed as well
+func nodeAddressesChanged(event string, node *corev1.Node) bool {
+	nodeAddressesMutex.Lock()
+	defer nodeAddressesMutex.Unlock()
+
+	name := node.GetMetadata().GetName()
+	previous, known := nodeAddresses[name]
+
+	if event == k8s.EventDeleted {
+		delete(nodeAddresses, name)
+		return previous != ""
+	}
+
+	ipAddresses := getReadyNodeExternalIPAddresses(node)
+	sort.Strings(ipAddresses)
+	current := strings.Join(ipAddresses, ",")
+	nodeAddresses[name] = current
+
+	if !known && event == k8s.EventAdded {
+		return false
+	}
+	return current != previous
+}
+
+// getNodeExternalIPsServiceWorkItems returns the work items for the services with dns enabled that use the nodeExternalIPs address
+// source, so they're processed again when the addresses of the nodes change
+func getNodeExternalIPsServiceWorkItems(client *k8s.Client, initiator string) (items []workItem, err error) {
+
+	for _, namespace := range watchNamespaces() {
+		var services corev1.ServiceList
+		err = client.List(context.Background(), namespace, &services, listOptions()...)
+		if err != nil {
+			return
+		}
+
+		for _, service := range services.Items {
+			if usesNodeExternalIPs(service) {
+				items = append(items, newWorkItem(serviceKind.kind, service, initiator))
+			}
+		}
+	}
+
+	return
+}
+
+// usesNodeExternalIPs returns true if a service has dns enabled and uses the nodeExternalIPs address source, in any case like the
+// address source annotation is read in
+func usesNodeExternalIPs(service *corev1.Service) bool {
+	if service.GetMetadata().GetAnnotations()[annotationGoogleCloudDNS] != "true" {
+		return false
+	}
+	source, err := getServiceAddressSource(service)
+	return err == nil && source == addressSourceNodeExternalIPs
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.GetStatus().GetConditions() {
+		if condition.GetType() == "Ready" {
+			return condition.GetStatus() == "True"
+		}
+	}
+	return false
+}
+
+func isIPv4Address(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && ip.To4() != nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+func TestNodeAddressesChanged(t *testing.T) {
+
+	node := func(ready bool, ips ...string) *corev1.Node {
+		status := "False"
+		if ready {
+			status = "True"
+		}
+		addresses := []*corev1.NodeAddress{{Type: k8s.String("InternalIP"), Address: k8s.String("10.0.0.1")}}
+		for _, ip := range ips {
+			addresses = append(addresses, &corev1.NodeAddress{Type: k8s.String("ExternalIP"), Address: k8s.String(ip)})
+		}
+		return &corev1.Node{
+			Metadata: &metav1.ObjectMeta{Name: k8s.String("node-1")},
+			Status: &corev1.NodeStatus{
+				Conditions: []*corev1.NodeCondition{{Type: k8s.String("Ready"), Status: k8s.String(status)}},
+				Addresses:  addresses,
+			},
+		}
+	}
+
+	// the steps run in order against the same nodes, like the events of a single watch
+	steps := []struct {
+		name  string
+		event string
+		node  *corev1.Node
+		want  bool
+	}{
+		{"listed for the first time", k8s.EventAdded, node(true, "1.2.3.4"), false},
+		{"unchanged", k8s.EventModified, node(true, "1.2.3.4"), false},
+		{"other order of the same addresses", k8s.EventModified, node(true, "1.2.3.4", "1.2.3.4"), false},
+		{"becomes unready", k8s.EventModified, node(false, "1.2.3.4"), true},
+		{"still unready with another address", k8s.EventModified, node(false, "5.6.7.8"), false},
+		{"becomes ready", k8s.EventModified, node(true, "5.6.7.8"), true},
+		{"gets another address", k8s.EventModified, node(true, "5.6.7.8", "9.9.9.9"), true},
+		{"only ipv6 addresses don't count", k8s.EventModified, node(true, "5.6.7.8", "9.9.9.9", "2001:db8::1"), false},
+		{"leaves", k8s.EventDeleted, node(true, "5.6.7.8", "9.9.9.9"), true},
+		{"joins through the watch", k8s.EventModified, node(true, "5.6.7.8"), true},
+	}
+
+	nodeAddresses = map[string]string{}
+	for _, step := range steps {
+		if got := nodeAddressesChanged(step.event, step.node); got != step.want {
+			t.Errorf("%v: nodeAddressesChanged() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestUsesNodeExternalIPs(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        bool
+	}{
+		{"node external ips", map[string]string{annotationGoogleCloudDNS: "true", annotationGoogleCloudDNSAddressSource: "nodeExternalIPs"}, true},
+		{"node external ips in another case", map[string]string{annotationGoogleCloudDNS: "true", annotationGoogleCloudDNSAddressSource: "NodeExternalIps"}, true},
+		{"other address source", map[string]string{annotationGoogleCloudDNS: "true", annotationGoogleCloudDNSAddressSource: "clusterIP"}, false},
+		{"unknown address source", map[string]string{annotationGoogleCloudDNS: "true", annotationGoogleCloudDNSAddressSource: "nodes"}, false},
+		{"without address source", map[string]string{annotationGoogleCloudDNS: "true"}, false},
+		{"dns disabled", map[string]string{annotationGoogleCloudDNSAddressSource: "nodeExternalIPs"}, false},
+	}
+
+	for _, tt := range tests {
+		service := &corev1.Service{Metadata: &metav1.ObjectMeta{Name: k8s.String("shop"), Annotations: tt.annotations}}
+		if got := usesNodeExternalIPs(service); got != tt.want {
+			t.Errorf("%v: usesNodeExternalIPs() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetServiceIPAddressesWithNodeExternalIPsDisabled(t *testing.T) {
+
+	defaultEnableNodeExternalIPs := *enableNodeExternalIPs
+	defer func() { *enableNodeExternalIPs = defaultEnableNodeExternalIPs }()
+	*enableNodeExternalIPs = false
+
+	service := &corev1.Service{
+		Metadata: &metav1.ObjectMeta{Name: k8s.String("shop"), Annotations: map[string]string{annotationGoogleCloudDNSAddressSource: "nodeExternalIPs"}},
+		Spec:     &corev1.ServiceSpec{Type: k8s.String("NodePort")},
+	}
+
+	// the nodes aren't listed, since the controller may not be allowed to
+	_, err := getServiceIPAddresses(nil, service)
+	if _, ok := err.(*invalidAddressSourceError); !ok {
+		t.Errorf("getServiceIPAddresses() error = %v, want an *invalidAddressSourceError", err)
+	}
+}
+
+func TestGetServiceExternalName(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		externalName string
+		annotations  map[string]string
+		want         string
+		wantErr      bool
+	}{
+		{name: "without trailing dot", externalName: "example.saas-provider.com", want: "example.saas-provider.com."},
+		{name: "with trailing dot", externalName: "example.saas-provider.com.", want: "example.saas-provider.com."},
+		{name: "upper case", externalName: "Example.SaaS-Provider.com", want: "example.saas-provider.com."},
+		{name: "empty", externalName: "", wantErr: true},
+		{name: "only a dot", externalName: ".", wantErr: true},
+		{name: "single label", externalName: "localhost", wantErr: true},
+		{name: "label longer than 63 characters", externalName: strings.Repeat("a", 64) + ".example.com", wantErr: true},
+		{name: "with an address source", externalName: "example.saas-provider.com", annotations: map[string]string{annotationGoogleCloudDNSAddressSource: "clusterIP"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		service := &corev1.Service{
+			Metadata: &metav1.ObjectMeta{Name: k8s.String("shop"), Annotations: tt.annotations},
+			Spec:     &corev1.ServiceSpec{Type: k8s.String("ExternalName"), ExternalName: k8s.String(tt.externalName)},
+		}
+
+		got, err := getServiceExternalName(service)
+		if tt.wantErr {
+			if _, ok := err.(*invalidAddressSourceError); !ok {
+				t.Errorf("%v: getServiceExternalName() error = %v, want an *invalidAddressSourceError", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%v: getServiceExternalName() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ericchiang/k8s"
+	metav1 "github.com/ericchiang/k8s/apis/meta/v1"
+)
+
+const (
+	// stateStoreAnnotation stores the state in the state annotation of the object itself
+	stateStoreAnnotation string = "annotation"
+	// stateStoreCustomResource stores the state in a DNSRecordState resource next to the object, so the object isn't changed
+	// apart from its finalizer
+	stateStoreCustomResource string = "crd"
+)
+
+// estafetteDNSAPIGroup is the api group of the custom resources of the controller
+const estafetteDNSAPIGroup string = "dns.estafette.io"
+
+// DNSRecordState represents a dns.estafette.io/v1 dns record state, holding the state of the dns records of the object it's owned by
+type DNSRecordState struct {
+	Kind       string              `json:"kind,omitempty"`
+	APIVersion string              `json:"apiVersion,omitempty"`
+	Metadata   *metav1.ObjectMeta  `json:"metadata"`
+	State      GoogleCloudDNSState `json:"state"`
+}
+
+// GetMetadata returns the metadata of the dns record state, required to implement k8s.Resource
+func (s *DNSRecordState) GetMetadata() *metav1.ObjectMeta {
+	return s.Metadata
+}
+
+// DNSRecordStateList represents a list of dns record states
+type DNSRecordStateList struct {
+	Metadata *metav1.ListMeta  `json:"metadata"`
+	Items    []*DNSRecordState `json:"items"`
+}
+
+// GetMetadata returns the metadata of the dns record state list, required to implement k8s.ResourceList
+func (l *DNSRecordStateList) GetMetadata() *metav1.ListMeta {
+	return l.Metadata
+}
+
+func init() {
+	k8s.Register(estafetteDNSAPIGroup, "v1", "dnsrecordstates", true, &DNSRecordState{})
+	k8s.RegisterList(estafetteDNSAPIGroup, "v1", "dnsrecordstates", true, &DNSRecordStateList{})
+}
+
+// dnsRecordStateName returns the name of the dns record state of an object, prefixed with its kind so a service and an ingress with
+// the same name don't share it
+func dnsRecordStateName(kind, name string) string {
+	return fmt.Sprintf("%v-%v", strings.ToLower(kind), name)
+}
+
+// getStoredState returns the state of an object from the configured state store; objects without a dns record state fall back to
+// their state annotation, so objects synced before switching to the custom resource keep their records
+func getStoredState(client *k8s.Client, kind string, metadata *metav1.ObjectMeta) (GoogleCloudDNSState, error) {
+
+	// the state of a dns endpoint is kept in its status
+	if strings.ToLower(kind) == dnsEndpointKind.kind {
+		var endpoint DNSEndpoint
+		err := client.Get(context.Background(), metadata.GetNamespace(), metadata.GetName(), &endpoint)
+		if err != nil {
+			return GoogleCloudDNSState{}, err
+		}
+		return getDNSEndpointState(&endpoint), nil
+	}
+
+	// nodes aren't within a namespace to keep a dns record state in, so their state is always kept in the annotation
+	if *stateStore != stateStoreCustomResource || strings.ToLower(kind) == nodeKind.kind {
+		return getCurrentState(metadata.Annotations), nil
+	}
+
+	var recordState DNSRecordState
+	err := client.Get(context.Background(), metadata.GetNamespace(), dnsRecordStateName(kind, metadata.GetName()), &recordState)
+	if isAPIError(err, http.StatusNotFound) {
+		return getCurrentState(metadata.Annotations), nil
+	}
+	if err != nil {
+		return GoogleCloudDNSState{}, err
+	}
+
+	return migrateState(recordState.State), nil
+}
+
+// storeStateResource creates or updates the dns record state of an object, owned by the object so kubernetes deletes it along with
+// the object
+func storeStateResource(client *k8s.Client, kind string, metadata *metav1.ObjectMeta, state GoogleCloudDNSState) error {
+
+	name := dnsRecordStateName(kind, metadata.GetName())
+
+	for attempt := 1; ; attempt++ {
+		var recordState DNSRecordState
+		err := client.Get(context.Background(), metadata.GetNamespace(), name, &recordState)
+		if isAPIError(err, http.StatusNotFound) {
+			recordState = DNSRecordState{
+				Kind:       "DNSRecordState",
+				APIVersion: fmt.Sprintf("%v/v1", estafetteDNSAPIGroup),
+				Metadata: &metav1.ObjectMeta{
+					Name:      k8s.String(name),
+					Namespace: k8s.String(metadata.GetNamespace()),
+					OwnerReferences: []*metav1.OwnerReference{
+						{
+							ApiVersion: k8s.String(involvedObjectAPIVersion(kind)),
+							Kind:       k8s.String(kind),
+							Name:       k8s.String(metadata.GetName()),
+							Uid:        k8s.String(metadata.GetUid()),
+							Controller: k8s.Bool(true),
+						},
+					},
+				},
+				State: state,
+			}
+			err = client.Create(context.Background(), &recordState)
+		} else if err == nil {
+			recordState.State = state
+			err = client.Update(context.Background(), &recordState)
+		}
+
+		// a conflict means the state was written concurrently, for example by a replica that just lost the lease
+		if err == nil || !isAPIError(err, http.StatusConflict) || attempt >= mergePatchRetries {
+			return err
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ericchiang/k8s"
+	corev1 "github.com/ericchiang/k8s/apis/core/v1"
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// eventBookmark is the type of watch event that only carries a new resource version
+const eventBookmark string = "BOOKMARK"
+
+// watchedKind describes how to list and watch a kind of object
+type watchedKind struct {
+	// kind is the name of the kind as used in work items and metrics
+	kind string
+	// name is the plural name of the kind as used in log messages
+	name      string
+	newObject func() k8s.Resource
+	newList   func() k8s.ResourceList
+	listItems func(k8s.ResourceList) []k8s.Resource
+	// clusterScoped kinds aren't within a namespace, so the namespace and label filters of the controller don't apply to them
+	clusterScoped bool
+	// attached kinds are only watched for the objects they're attached to, so the label filter applies to those objects instead
+	attached bool
+}
+
+// listOptions returns the options to filter list and watch calls for the kind with
+func (kind watchedKind) listOptions() []k8s.Option {
+	if kind.clusterScoped {
+		return nil
+	}
+	if kind.attached {
+		return namespaceListOptions()
+	}
+	return listOptions()
+}
+
+var serviceKind = watchedKind{
+	kind:      "service",
+	name:      "services",
+	newObject: func() k8s.Resource { return new(corev1.Service) },
+	newList:   func() k8s.ResourceList { return new(corev1.ServiceList) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range list.(*corev1.ServiceList).Items {
+			items = append(items, item)
+		}
+		return
+	},
+}
+
+var ingressKind = watchedKind{
+	kind:      "ingress",
+	name:      "ingresses",
+	newObject: newIngressResource,
+	newList:   func() k8s.ResourceList { return newIngressResourceList(ingressAPIVersion) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range toIngressList(list).Items {
+			items = append(items, ingressResource(item))
+		}
+		return
+	},
+}
+
+var gatewayKind = watchedKind{
+	kind:      "gateway",
+	name:      "gateways",
+	newObject: func() k8s.Resource { return new(Gateway) },
+	newList:   func() k8s.ResourceList { return new(GatewayList) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range list.(*GatewayList).Items {
+			items = append(items, item)
+		}
+		return
+	},
+}
+
+var dnsEndpointKind = watchedKind{
+	kind:      "dnsendpoint",
+	name:      "dns endpoints",
+	newObject: func() k8s.Resource { return new(DNSEndpoint) },
+	newList:   func() k8s.ResourceList { return new(DNSEndpointList) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range list.(*DNSEndpointList).Items {
+			items = append(items, item)
+		}
+		return
+	},
+}
+
+var httpRouteKind = watchedKind{
+	kind:      "httproute",
+	name:      "http routes",
+	newObject: func() k8s.Resource { return new(HTTPRoute) },
+	newList:   func() k8s.ResourceList { return new(HTTPRouteList) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range list.(*HTTPRouteList).Items {
+			items = append(items, item)
+		}
+		return
+	},
+	attached: true,
+}
+
+var nodeKind = watchedKind{
+	kind:      "node",
+	name:      "nodes",
+	newObject: func() k8s.Resource { return new(corev1.Node) },
+	newList:   func() k8s.ResourceList { return new(corev1.NodeList) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range list.(*corev1.NodeList).Items {
+			items = append(items, item)
+		}
+		return
+	},
+	clusterScoped: true,
+}
+
+var endpointSliceKind = watchedKind{
+	kind:      "endpointslice",
+	name:      "endpoint slices",
+	newObject: func() k8s.Resource { return new(EndpointSlice) },
+	newList:   func() k8s.ResourceList { return new(EndpointSliceList) },
+	listItems: func(list k8s.ResourceList) (items []k8s.Resource) {
+		for _, item := range list.(*EndpointSliceList).Items {
+			items = append(items, item)
+		}
+		return
+	},
+	attached: true,
+}
+
+// reconciledKinds returns the kinds of objects dns records are set for
+func reconciledKinds() []watchedKind {
+	kinds := []watchedKind{serviceKind, ingressKind}
+	if *enableGatewayAPI {
+		kinds = append(kinds, gatewayKind)
+	}
+	if *enableDNSEndpoints {
+		kinds = append(kinds, dnsEndpointKind)
+	}
+	return kinds
+}
+
+// watchedScope is a kind of object to list and watch within a namespace, or across the cluster for cluster-scoped kinds
+type watchedScope struct {
+	namespace string
+	kind      watchedKind
+}
+
+// reconciledScopes returns the kinds of objects dns records are set for within each of the namespaces to watch, and the cluster-scoped
+// kinds once
+func reconciledScopes() (scopes []watchedScope) {
+	for _, namespace := range watchNamespaces() {
+		for _, kind := range reconciledKinds() {
+			scopes = append(scopes, watchedScope{namespace: namespace, kind: kind})
+		}
+	}
+	if *enableNodes {
+		scopes = append(scopes, watchedScope{namespace: k8s.AllNamespaces, kind: nodeKind})
+	}
+	return
+}
+
+// listAndWatch lists the objects of a kind once and then keeps watching them, resuming every watch from the last seen resource
+// version so no events are missed when a watch times out or breaks; only when the resource version has expired the objects are
+// listed again, in which case just the objects that changed in the meantime are handled
+func listAndWatch(client *k8s.Client, namespace string, kind watchedKind, handle func(event string, resource k8s.Resource)) {
+
+	// resource version per object that was last handled, to skip unchanged objects after a relist
+	handledVersions := map[string]string{}
+	resourceVersion := ""
+
+	// loop indefinitely
+	for {
+		if resourceVersion == "" {
+			log.Info().Msgf("Listing %v for %v...", kind.name, namespaceDescription(namespace))
+
+			list := kind.newList()
+			err := client.List(context.Background(), namespace, list, kind.listOptions()...)
+			if err != nil {
+				log.Error().Err(err).Msgf("Listing %v for %v failed", kind.name, namespaceDescription(namespace))
+				sleepBeforeRetry()
+				continue
+			}
+			resourceVersion = list.GetMetadata().GetResourceVersion()
+
+			listedVersions := map[string]string{}
+			for _, item := range kind.listItems(list) {
+				key := fmt.Sprintf("%v/%v", item.GetMetadata().GetNamespace(), item.GetMetadata().GetName())
+				listedVersions[key] = item.GetMetadata().GetResourceVersion()
+				if handledVersions[key] == listedVersions[key] {
+					continue
+				}
+				handle(k8s.EventAdded, item)
+			}
+			handledVersions = listedVersions
+		}
+
+		log.Debug().Msgf("Watching %v for %v from resource version %v...", kind.name, namespaceDescription(namespace), resourceVersion)
+
+		watcher, err := client.Watch(context.Background(), namespace, kind.newObject(), append(kind.listOptions(), k8s.ResourceVersion(resourceVersion), k8s.QueryParam("allowWatchBookmarks", "true"), k8s.Timeout(time.Duration(300)*time.Second))...)
+		if err != nil {
+			if isAPIError(err, http.StatusGone) {
+				log.Info().Msgf("Resource version %v of %v for %v has expired, listing again...", resourceVersion, kind.name, namespaceDescription(namespace))
+				resourceVersion = ""
+				continue
+			}
+			log.Error().Err(err).Msgf("Watching %v for %v failed", kind.name, namespaceDescription(namespace))
+			sleepBeforeRetry()
+			continue
+		}
+
+		watchStarted := time.Now()
+		eventsReceived := 0
+
+		// loop until the watch times out or errors
+		for {
+			resource := kind.newObject()
+			event, err := watcher.Next(resource)
+			if err != nil {
+				// the api server closes the watch once the timeout has passed, it's resumed right away; for protobuf encoded kinds an
+				// error event can't be decoded and surfaces as an error right after starting the watch, so that's treated as expired
+				if eventsReceived == 0 && time.Since(watchStarted) < 5*time.Second {
+					log.Info().Err(err).Msgf("Watch of %v for %v failed right away, listing again...", kind.name, namespaceDescription(namespace))
+					resourceVersion = ""
+					break
+				}
+				log.Debug().Err(err).Msgf("Watch of %v for %v has ended", kind.name, namespaceDescription(namespace))
+				break
+			}
+			eventsReceived++
+
+			if event == k8s.EventError {
+				// the object is a status instead; nearly always it's a 410 for an expired resource version
+				log.Info().Msgf("Watch of %v for %v returned an error event, listing again...", kind.name, namespaceDescription(namespace))
+				resourceVersion = ""
+				break
+			}
+
+			resourceVersion = resource.GetMetadata().GetResourceVersion()
+			if event == eventBookmark {
+				continue
+			}
+
+			key := fmt.Sprintf("%v/%v", resource.GetMetadata().GetNamespace(), resource.GetMetadata().GetName())
+			if event == k8s.EventDeleted {
+				delete(handledVersions, key)
+			} else {
+				handledVersions[key] = resourceVersion
+			}
+
+			handle(event, resource)
+		}
+
+		watcher.Close()
+	}
+}
+
+// sleepBeforeRetry sleeps a random time between 22 and 37 seconds before retrying a failed list or watch call
+func sleepBeforeRetry() {
+	sleepTime := foundation.ApplyJitter(30)
+	log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
+	time.Sleep(time.Duration(sleepTime) * time.Second)
+}
+
+// isAPIError returns true if the error is a kubernetes api error with the http status code
+func isAPIError(err error, code int) bool {
+	apiErr, ok := err.(*k8s.APIError)
+	return ok && apiErr.Code == code
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ericchiang/k8s"
+)
+
+const (
+	// workItemRetryBaseDelay is the delay before retrying an item that failed once; it doubles with every next failure
+	workItemRetryBaseDelay = 1 * time.Second
+	// workItemRetryMaxDelay caps the delay before retrying an item that keeps failing
+	workItemRetryMaxDelay = 5 * time.Minute
+)
+
+// workItem identifies an object to reconcile; the object itself is retrieved when the item gets processed, so it's never stale
+type workItem struct {
+	Namespace string
+	Kind      string
+	Name      string
+	// Initiator is what queued the item, like watcher:MODIFIED or poller
+	Initiator string
+}
+
+// newWorkItem returns the work item for an object
+func newWorkItem(kind string, resource k8s.Resource, initiator string) workItem {
+	return workItem{
+		Namespace: resource.GetMetadata().GetNamespace(),
+		Kind:      kind,
+		Name:      resource.GetMetadata().GetName(),
+		Initiator: initiator,
+	}
+}
+
+// Key returns the key items are deduplicated by, in the form namespace/kind/name
+func (item workItem) Key() string {
+	return fmt.Sprintf("%v/%v/%v", item.Namespace, item.Kind, item.Name)
+}
+
+// InitiatorType returns the initiator without the event type, as used in metrics
+func (item workItem) InitiatorType() string {
+	return strings.Split(item.Initiator, ":")[0]
+}
+
+// workQueue is a queue of work items shared by the watchers and the poller; an item that is already queued isn't queued again,
+// and an item that is queued while it's being processed is held back until processing is done, so the same object is never
+// processed concurrently
+type workQueue struct {
+	mutex *sync.Mutex
+	cond  *sync.Cond
+
+	queue      []string
+	pending    map[string]workItem
+	processing map[string]bool
+	// dirty holds the items that were queued while being processed
+	dirty    map[string]workItem
+	failures map[string]int
+
+	shuttingDown bool
+}
+
+// newWorkQueue returns an empty work queue
+func newWorkQueue() *workQueue {
+	mutex := &sync.Mutex{}
+	return &workQueue{
+		mutex:      mutex,
+		cond:       sync.NewCond(mutex),
+		queue:      []string{},
+		pending:    map[string]workItem{},
+		processing: map[string]bool{},
+		dirty:      map[string]workItem{},
+		failures:   map[string]int{},
+	}
+}
+
+// Add queues an item, unless it's already queued
+func (q *workQueue) Add(item workItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.shuttingDown {
+		return
+	}
+
+	key := item.Key()
+	if q.processing[key] {
+		q.dirty[key] = item
+		return
+	}
+	if _, ok := q.pending[key]; ok {
+		q.pending[key] = item
+		return
+	}
+
+	q.pending[key] = item
+	q.queue = append(q.queue, key)
+	q.cond.Signal()
+}
+
+// AddRateLimited queues a failed item again after a delay that grows with the number of times the item failed in a row
+func (q *workQueue) AddRateLimited(item workItem) {
+	q.mutex.Lock()
+	key := item.Key()
+	q.failures[key]++
+	delay := workItemRetryBaseDelay * time.Duration(1<<uint(q.failures[key]-1))
+	if delay > workItemRetryMaxDelay || delay <= 0 {
+		delay = workItemRetryMaxDelay
+	}
+	q.mutex.Unlock()
+
+	q.AddAfter(item, delay)
+}
+
+// AddAfter queues an item once the delay has passed
+func (q *workQueue) AddAfter(item workItem, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		q.Add(item)
+	})
+}
+
+// Forget resets the failure count of an item after it has been processed successfully
+func (q *workQueue) Forget(item workItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.failures, item.Key())
+}
+
+// Get blocks until an item is available and marks it as being processed; it returns false once the queue is shut down
+func (q *workQueue) Get() (item workItem, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.queue) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if q.shuttingDown {
+		return item, false
+	}
+
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	item = q.pending[key]
+	delete(q.pending, key)
+	q.processing[key] = true
+
+	return item, true
+}
+
+// Done marks an item as processed; if it was queued again in the meantime it's put back in the queue
+func (q *workQueue) Done(item workItem) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := item.Key()
+	delete(q.processing, key)
+
+	if dirtyItem, ok := q.dirty[key]; ok {
+		delete(q.dirty, key)
+		if !q.shuttingDown {
+			q.pending[key] = dirtyItem
+			q.queue = append(q.queue, key)
+			q.cond.Signal()
+		}
+	}
+}
+
+// Len returns the number of queued items
+func (q *workQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.queue)
+}
+
+// ShutDown stops handing out items, so workers can finish
+func (q *workQueue) ShutDown() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWorkQueue(t *testing.T) {
+
+	service := func(name, initiator string) workItem {
+		return workItem{Namespace: "default", Kind: "service", Name: name, Initiator: initiator}
+	}
+
+	tests := []struct {
+		name string
+		run  func(q *workQueue)
+		// wantLen and wantKeys are the number of queued items and the keys they're handed out in afterwards
+		wantLen  int
+		wantKeys []string
+	}{
+		{
+			name: "deduplicates pending items",
+			run: func(q *workQueue) {
+				q.Add(service("a", "watcher:ADDED"))
+				q.Add(service("b", "poller"))
+				q.Add(service("a", "poller"))
+			},
+			wantLen:  2,
+			wantKeys: []string{"default/service/a", "default/service/b"},
+		},
+		{
+			name: "holds back an item queued while being processed until it's done",
+			run: func(q *workQueue) {
+				q.Add(service("a", "watcher:ADDED"))
+				item, _ := q.Get()
+				q.Add(service("a", "watcher:MODIFIED"))
+				if q.Len() != 0 {
+					t.Errorf("item being processed was queued again before it was done")
+				}
+				q.Done(item)
+			},
+			wantLen:  1,
+			wantKeys: []string{"default/service/a"},
+		},
+		{
+			name: "doesn't queue an item again when it's done without changes",
+			run: func(q *workQueue) {
+				q.Add(service("a", "watcher:ADDED"))
+				item, _ := q.Get()
+				q.Done(item)
+			},
+			wantLen: 0,
+		},
+		{
+			name: "ignores items after shutting down",
+			run: func(q *workQueue) {
+				q.ShutDown()
+				q.Add(service("a", "poller"))
+			},
+			wantLen: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		q := newWorkQueue()
+		tt.run(q)
+
+		if q.Len() != tt.wantLen {
+			t.Errorf("%v: Len() = %v, want %v", tt.name, q.Len(), tt.wantLen)
+			continue
+		}
+		for _, wantKey := range tt.wantKeys {
+			item, ok := q.Get()
+			if !ok || item.Key() != wantKey {
+				t.Errorf("%v: Get() = %v, %v, want %v", tt.name, item.Key(), ok, wantKey)
+			}
+			q.Done(item)
+		}
+	}
+}
+
+func TestWorkQueueKeepsLatestInitiator(t *testing.T) {
+
+	q := newWorkQueue()
+	q.Add(workItem{Namespace: "default", Kind: "service", Name: "a", Initiator: "poller"})
+	q.Add(workItem{Namespace: "default", Kind: "service", Name: "a", Initiator: "watcher:MODIFIED"})
+
+	item, ok := q.Get()
+	if !ok || item.Initiator != "watcher:MODIFIED" {
+		t.Errorf("Get() = %v, %v, want the item with initiator watcher:MODIFIED", item, ok)
+	}
+	if item.InitiatorType() != "watcher" {
+		t.Errorf("InitiatorType() = %v, want watcher", item.InitiatorType())
+	}
+}
+
+func TestWorkQueueGetAfterShutDown(t *testing.T) {
+
+	q := newWorkQueue()
+	q.Add(workItem{Namespace: "default", Kind: "service", Name: "a"})
+	q.ShutDown()
+
+	if _, ok := q.Get(); ok {
+		t.Errorf("Get() returned an item after shutting down")
+	}
+}
+
+func TestWorkQueueForget(t *testing.T) {
+
+	q := newWorkQueue()
+	item := workItem{Namespace: "default", Kind: "service", Name: "a"}
+
+	q.AddRateLimited(item)
+	q.AddRateLimited(item)
+	if q.failures[item.Key()] != 2 {
+		t.Errorf("failures = %v after failing twice, want 2", q.failures[item.Key()])
+	}
+
+	q.Forget(item)
+	if _, ok := q.failures[item.Key()]; ok {
+		t.Errorf("failures = %v after forgetting the item, want none", q.failures[item.Key()])
+	}
+	q.ShutDown()
+}